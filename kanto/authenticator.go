@@ -0,0 +1,170 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for user authentication backends
+package kanto
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AUTH_TYPE_FILE = "file"
+	AUTH_TYPE_NONE = "none"
+)
+
+// default path to user store file, can be overwritten by os ENV "AUTH_FILE"
+var AUTH_FILE string = "./kanto_users"
+
+// authenticator used by all web api handlers, configured in main
+// default is to deny everyone until real authenticator is configured
+var AUTHENTICATOR Authenticator = &DenyAllAuthenticator{}
+
+// interface for pluggable user authentication
+// every backend has to be able to validate username and token pair
+type Authenticator interface {
+	// check if token is valid for username
+	// @param username string
+	// @param token string
+	// @return bool - true if credentials are valid
+	// @return error - any error that occurs during authentication (ie. user store is not readable)
+	Authenticate(username string, token string) (bool, error)
+}
+
+// authenticator that refuses every request
+// used when no other authenticator is configured
+type DenyAllAuthenticator struct{}
+
+func (a *DenyAllAuthenticator) Authenticate(username string, token string) (bool, error) {
+	return false, nil
+}
+
+// authenticator that accepts every request with non-empty username
+// only for development, same behaviour as old dummy authentication
+type AllowAllAuthenticator struct{}
+
+func (a *AllowAllAuthenticator) Authenticate(username string, token string) (bool, error) {
+	return username != "", nil
+}
+
+// file backed user store
+// file format is one user per line "username:bcrypt_hash", lines starting with "#" are ignored
+// it is compatible with "htpasswd -B" output
+// file is reloaded automatically when its modification time changes
+type FileAuthenticator struct {
+	// path to user file
+	Path string
+
+	mutex   sync.RWMutex
+	users   map[string][]byte
+	modTime time.Time
+}
+
+// create file authenticator and load users from file
+// @param path string - path to user store file
+// @return *FileAuthenticator
+// @return error - error if file cannot be loaded
+func NewFileAuthenticator(path string) (*FileAuthenticator, error) {
+	a := &FileAuthenticator{Path: path, users: make(map[string][]byte)}
+	// initial load
+	err := a.Reload()
+	return a, err
+}
+
+// validate username and token against bcrypt hash stored in file
+// @param username string
+// @param token string
+// @return bool - true if credentials are valid
+// @return error
+func (a *FileAuthenticator) Authenticate(username string, token string) (bool, error) {
+	if username == "" || token == "" {
+		return false, nil
+	}
+	// reload file if it was changed since last load
+	if err := a.reloadIfChanged(); err != nil {
+		ErrorLog("authenticator: file authenticator: reload user file error")
+		ErrorLog(err)
+		// continue with already loaded users
+	}
+	a.mutex.RLock()
+	hash, ok := a.users[username]
+	a.mutex.RUnlock()
+	if !ok {
+		// unknown user
+		return false, nil
+	}
+	// compare stored hash with token
+	err := bcrypt.CompareHashAndPassword(hash, []byte(token))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// load all users from file, replaces all previously loaded users
+// @return error
+func (a *FileAuthenticator) Reload() error {
+	file, err := os.Open(a.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// file info for modification time
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return errors.New("authenticator: invalid line in user file " + a.Path)
+		}
+		users[parts[0]] = []byte(parts[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// swap loaded users
+	a.mutex.Lock()
+	a.users = users
+	a.modTime = info.ModTime()
+	a.mutex.Unlock()
+
+	DebugLog("authenticator: loaded users from file " + a.Path)
+	return nil
+}
+
+// reload user file only if modification time changed
+// @return error
+func (a *FileAuthenticator) reloadIfChanged() error {
+	info, err := os.Stat(a.Path)
+	if err != nil {
+		return err
+	}
+	a.mutex.RLock()
+	changed := !info.ModTime().Equal(a.modTime)
+	a.mutex.RUnlock()
+
+	if changed {
+		return a.Reload()
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// typed go client for kanto v1 API
+//
+//	c := client.New("http://127.0.0.1:80", "user1", "43ggDWgv4")
+//	cluster, op, err := c.Create("mycluster-1", 3, nil)
+//	op, err = c.Wait(op.Id, 5*time.Minute)
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/calvix/kanto/kanto"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
+)
+
+const (
+	// default timeout for one http request
+	DEFAULT_TIMEOUT = 30 * time.Second
+	// default interval between two operation status requests in Wait
+	DEFAULT_POLL_INTERVAL = 2 * time.Second
+
+	CLUSTERS_PATH   = "/v1/clusters"
+	OPERATIONS_PATH = "/v1/operations"
+)
+
+// error returned when Wait reaches timeout before operation finishes
+var ErrWaitTimeout = errors.New("kanto client: timeout while waiting for operation")
+
+// error returned by kanto API, KantoResponse with status other than "ok"
+type APIError struct {
+	// http status code
+	StatusCode int
+	// KantoResponse status ("error", "unauthorized")
+	Status  string
+	Message string
+	Detail  string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("kanto: %s (http %d): %s", e.Status, e.StatusCode, e.Message)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+// error returned by Wait when operation finished with status "failed"
+type OperationError struct {
+	Operation *kanto.Operation
+}
+
+func (e *OperationError) Error() string {
+	return "kanto: operation " + e.Operation.Id + " (" + e.Operation.Type + ") failed: " + e.Operation.Error
+}
+
+// kanto API client
+type Client struct {
+	// kanto url, ie. http://127.0.0.1:80
+	BaseURL  string
+	Username string
+	Token    string
+	// http client used for requests, its Timeout is timeout of one request
+	HTTPClient *http.Client
+	// interval between two operation status requests in Wait
+	PollInterval time.Duration
+}
+
+// optional settings of new cluster, nil fields use kanto defaults
+type CreateOptions struct {
+	// cpu and memory requests and limits of couchdb containers
+	Resources *api.ResourceRequirements
+	// size and storage class of volume claims
+	Storage *kanto.ClusterStorage
+	// anti-affinity and zone spreading of replicas
+	Placement *kanto.ClusterPlacement
+	// exposure outside of kubernetes, one of kanto.EXPOSURE_*
+	Exposure string
+}
+
+// create new client with default timeouts
+// @param baseURL string - kanto url
+// @param username string
+// @param token string
+// @return *Client
+func New(baseURL string, username string, token string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Username: username, Token: token,
+		HTTPClient: &http.Client{Timeout: DEFAULT_TIMEOUT}, PollInterval: DEFAULT_POLL_INTERVAL}
+}
+
+// set timeout for one http request
+// @param timeout time.Duration
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.HTTPClient.Timeout = timeout
+}
+
+// list all clusters of user
+// @return []kanto.CouchdbCluster
+// @return error
+func (c *Client) List() ([]kanto.CouchdbCluster, error) {
+	clusters := []kanto.CouchdbCluster{}
+	err := c.do(kanto.METHOD_GET, CLUSTERS_PATH, nil, &clusters)
+	return clusters, err
+}
+
+// get cluster detail
+// @param tag string - cluster tag
+// @return *kanto.CouchdbCluster
+// @return error
+func (c *Client) Detail(tag string) (*kanto.CouchdbCluster, error) {
+	cluster := &kanto.CouchdbCluster{}
+	err := c.do(kanto.METHOD_GET, clusterPath(tag), nil, cluster)
+	return cluster, err
+}
+
+// get cluster admin credentials, returned cluster has Username and Password
+// @param tag string - cluster tag
+// @return *kanto.CouchdbCluster
+// @return error
+func (c *Client) Credentials(tag string) (*kanto.CouchdbCluster, error) {
+	cluster := &kanto.CouchdbCluster{}
+	err := c.do(kanto.METHOD_GET, clusterPath(tag)+"/credentials", nil, cluster)
+	return cluster, err
+}
+
+// start rotation of cluster admin password, use Credentials to get new password when operation finishes
+// @param tag string - cluster tag
+// @return *kanto.CouchdbCluster - cluster info
+// @return *kanto.Operation - started operation
+// @return error
+func (c *Client) RotateCredentials(tag string) (*kanto.CouchdbCluster, *kanto.Operation, error) {
+	return c.operation(kanto.METHOD_POST, clusterPath(tag)+"/credentials", nil)
+}
+
+// start creation of new cluster
+// returned cluster is known at time of request and contains generated admin password,
+// password is not part of operation later, use Credentials to get it again
+// use Wait to get endpoint of finished cluster
+// @param tag string - cluster tag, empty tag means generated tag
+// @param replicas int32
+// @param options *CreateOptions - optional settings, nil uses kanto defaults
+// @return *kanto.CouchdbCluster - cluster info
+// @return *kanto.Operation - started operation
+// @return error
+func (c *Client) Create(tag string, replicas int32, options *CreateOptions) (*kanto.CouchdbCluster, *kanto.Operation, error) {
+	request := kanto.CouchdbCluster{Tag: tag, Replicas: replicas}
+	if options != nil {
+		request.Resources = options.Resources
+		request.Storage = options.Storage
+		request.Placement = options.Placement
+		request.Exposure = options.Exposure
+	}
+	return c.operation(kanto.METHOD_POST, CLUSTERS_PATH, request)
+}
+
+// start scaling of cluster
+// @param tag string - cluster tag
+// @param replicas int32 - new replica count, 0 keeps current replica count
+// @param resources *api.ResourceRequirements - changed cpu and memory, nil keeps current resources
+// @return *kanto.CouchdbCluster - cluster info
+// @return *kanto.Operation - started operation
+// @return error
+func (c *Client) Scale(tag string, replicas int32, resources *api.ResourceRequirements) (*kanto.CouchdbCluster, *kanto.Operation, error) {
+	request := kanto.CouchdbCluster{Replicas: replicas, Resources: resources}
+	return c.operation(kanto.METHOD_PATCH, clusterPath(tag), request)
+}
+
+// start resizing of cluster volumes
+// @param tag string - cluster tag
+// @param size resource.Quantity - new size of volume of each replica, cannot be smaller than current size
+// @return *kanto.CouchdbCluster - cluster info
+// @return *kanto.Operation - started operation
+// @return error
+func (c *Client) Resize(tag string, size resource.Quantity) (*kanto.CouchdbCluster, *kanto.Operation, error) {
+	request := kanto.ClusterStorage{Size: &size}
+	return c.operation(kanto.METHOD_PUT, clusterPath(tag)+"/storage", request)
+}
+
+// start deletion of cluster
+// @param tag string - cluster tag
+// @return *kanto.CouchdbCluster - cluster info
+// @return *kanto.Operation - started operation
+// @return error
+func (c *Client) Delete(tag string) (*kanto.CouchdbCluster, *kanto.Operation, error) {
+	return c.operation(kanto.METHOD_DELETE, clusterPath(tag), nil)
+}
+
+// start replication of databases in cluster
+// @param tag string - cluster tag
+// @param databases []string
+// @return *kanto.CouchdbCluster - cluster info
+// @return *kanto.Operation - started operation
+// @return error
+func (c *Client) Replicate(tag string, databases []string) (*kanto.CouchdbCluster, *kanto.Operation, error) {
+	request := kanto.CouchdbCluster{Databases: databases}
+	return c.operation(kanto.METHOD_PUT, clusterPath(tag)+"/replication", request)
+}
+
+// get operation status
+// @param id string - operation id
+// @return *kanto.Operation
+// @return error
+func (c *Client) Operation(id string) (*kanto.Operation, error) {
+	op := &kanto.Operation{}
+	err := c.do(kanto.METHOD_GET, OPERATIONS_PATH+"/"+url.QueryEscape(id), nil, op)
+	return op, err
+}
+
+// wait until operation finishes
+// @param id string - operation id
+// @param timeout time.Duration - max time to wait, 0 means no limit
+// @return *kanto.Operation - finished operation
+// @return error - ErrWaitTimeout, *OperationError if operation failed, or request error
+func (c *Client) Wait(id string, timeout time.Duration) (*kanto.Operation, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		op, err := c.Operation(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.Status {
+		case kanto.OPERATION_SUCCEEDED:
+			return op, nil
+		case kanto.OPERATION_FAILED:
+			return op, &OperationError{Operation: op}
+		}
+		if timeout > 0 && time.Now().Add(c.PollInterval).After(deadline) {
+			return op, ErrWaitTimeout
+		}
+		time.Sleep(c.PollInterval)
+	}
+}
+
+// decode cluster info from operation result
+// @param op *kanto.Operation
+// @return *kanto.CouchdbCluster
+// @return error
+func OperationCluster(op *kanto.Operation) (*kanto.CouchdbCluster, error) {
+	cluster := &kanto.CouchdbCluster{}
+	if op.Result == nil {
+		return cluster, nil
+	}
+	err := json.Unmarshal(*op.Result, cluster)
+	return cluster, err
+}
+
+// send request which starts operation
+func (c *Client) operation(method string, path string, body interface{}) (*kanto.CouchdbCluster, *kanto.Operation, error) {
+	op := &kanto.Operation{}
+	if err := c.do(method, path, body, op); err != nil {
+		return nil, nil, err
+	}
+	cluster, err := OperationCluster(op)
+	return cluster, op, err
+}
+
+// send request to kanto and decode KantoResponse result into v
+// @param method string - http method
+// @param path string - api path
+// @param body interface{} - request body marshaled to json, nil for no body
+// @param v interface{} - result is unmarshaled into v, can be nil
+// @return error - *APIError if kanto returned other status than "ok"
+func (c *Client) do(method string, path string, body interface{}, v interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(content)
+	}
+	req, err := http.NewRequest(method, c.BaseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.Username, c.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// every response is KantoResponse
+	result := kanto.KantoResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return &APIError{StatusCode: resp.StatusCode, Status: kanto.STATUS_ERROR,
+			Message: "invalid response from kanto", Detail: err.Error()}
+	}
+	if result.Status != kanto.STATUS_OK {
+		return &APIError{StatusCode: resp.StatusCode, Status: result.Status,
+			Message: result.StatusMessage, Detail: result.Error}
+	}
+	if v != nil && result.Result != nil {
+		return json.Unmarshal(*result.Result, v)
+	}
+	return nil
+}
+
+// api path for cluster
+func clusterPath(tag string) string {
+	return CLUSTERS_PATH + "/" + url.QueryEscape(tag)
+}
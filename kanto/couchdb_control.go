@@ -10,7 +10,6 @@ import (
 	"time"
 
 	//
-	"k8s.io/kubernetes/pkg/api"
 	"github.com/patrickjuchli/couch"
 	"errors"
 	"strconv"
@@ -23,6 +22,7 @@ const (
 	METHOD_PUT = "PUT"
 	METHOD_GET = "GET"
 	METHOD_DELETE = "DELETE"
+	METHOD_PATCH = "PATCH"
 
 )
 
@@ -35,41 +35,49 @@ func (cluster *CouchdbCluster) SetupReplication(databases []string) (error) {
 	DebugLog("couchdb_control: setup: _replication: Replication setup for all PODS, dbs to replicate:")
 	DebugLog(databases)
 	// check fi all pods are ready and in running state
+	cluster.Operation.StepRunning(STEP_PODS_READY)
 	err := cluster.CheckAllCouchdbPods()
 	if err != nil {
 		ErrorLog("couchdb_control: setup_replication: check all pods error")
 		return err
 	}
+	cluster.Operation.StepSucceeded(STEP_PODS_READY)
+	cluster.Operation.StepRunning(STEP_REPLICATION_CONFIGURED)
+	// load admin password from cluster secret
+	err = cluster.LoadCredentials()
+	if err != nil {
+		ErrorLog("couchdb_control: setup_replication: load credentials error")
+		return err
+	}
 	// create couchdb admin credentials
 	credentials := couch.NewCredentials(cluster.Username, cluster.Password)
 	// get all pods
-	podSvcList, err := cluster.GetAllPodServices()
+	peers, err := cluster.PeerEndpoints()
 	if err != nil {
-		ErrorLog("couchdb_control: setup_replication: check all pods error")
+		ErrorLog("couchdb_control: setup_replication: get peer endpoints error")
 		return err
 	}
-	podSvcs := *podSvcList
-	DebugLog("couchdb_control: setup_replication: interate throught all pod services")
+	DebugLog("couchdb_control: setup_replication: interate throught all pods")
 	// iterate through all pods
-	for i := 0 ; i < len(podSvcs) ; i++ {
+	for i := 0 ; i < len(peers) ; i++ {
 		// index of next pod
-		j := (i+1) % len(podSvcs)
-		DebugLog("couchdb_control: setup replication: pod: "+podSvcs[i].Name+","+podSvcs[i].Spec.ClusterIP)
+		j := (i+1) % len(peers)
+		DebugLog("couchdb_control: setup replication: pod: "+peers[i])
 
 		// primary - replicate FROM
-		server1 := couch.NewServer("http://"+podSvcs[i].Spec.ClusterIP+":"+COUCHDB_PORT_STRING, credentials)
+		server1 := couch.NewServer("http://"+peers[i]+":"+COUCHDB_PORT_STRING, credentials)
 		// check server1
 		if err := CheckServer(server1, MAX_RETRIES, RETRY_WAIT_TIME); err != nil {
 			// failed to connect to server after all retries, fail replication
-			ErrorLog("couchdb_control: setupReplication: failed to connect to server1, pod:"+podSvcs[i].Name)
+			ErrorLog("couchdb_control: setupReplication: failed to connect to server1, pod:"+peers[i])
 			ErrorLog(err)
 			return err
 		}
 		// secondary - replicate TO
-		server2 := couch.NewServer("http://"+podSvcs[j].Spec.ClusterIP+":"+COUCHDB_PORT_STRING, credentials)
+		server2 := couch.NewServer("http://"+peers[j]+":"+COUCHDB_PORT_STRING, credentials)
 		if err := CheckServer(server2, MAX_RETRIES, RETRY_WAIT_TIME); err != nil {
 			// failed to connect to server after all retries, fail replication
-			ErrorLog("couchdb_control: setupReplication: failed to connect to server2, pod:"+podSvcs[j].Name)
+			ErrorLog("couchdb_control: setupReplication: failed to connect to server2, pod:"+peers[j])
 			ErrorLog(err)
 			return err
 		}
@@ -87,12 +95,6 @@ func (cluster *CouchdbCluster) SetupReplication(databases []string) (error) {
 			// 1) using _replicate
 			// 2) using _replicator
 
-			// replication struct, use headless service name for replication target
-			replicator := CouchdbReplicator{Id:"replicate_"+db,
-						Continuous: true, Source:db1.URL(),
-						Target:"http://"+cluster.Username+":"+cluster.Password+"@"+podSvcs[j].Spec.ClusterIP+":"+COUCHDB_PORT_STRING+"/"+db}
-
-
 			// 1)
 			// continuous replication , saves to "_replicate"
 			// limits:  anything in _replication is lost when db is restarted
@@ -113,26 +115,175 @@ func (cluster *CouchdbCluster) SetupReplication(databases []string) (error) {
 				// there is a bug with _replicator and db _users, we cannot replicate this DB
 				continue
 			}
-			// delete old replication, if any found
-			// get old replicator record
-			oldReplicator := CouchdbReplicator{}
-			couch.Do(server1.URL()+"/_replicator/" + "replicate_" + db , METHOD_GET, server1.Cred(), nil, &oldReplicator)
-			//DebugLog(oldReplicator.Rev)
-			// if valid replicator record found, delete it
-			if oldReplicator.Rev != "" {
-				server1.Database("_replicator").Delete("replicate_" + db, oldReplicator.Rev)
-			}
-
-			// setup new replication in _replicator db
-			couch.Do(server1.URL()+"/_replicator", METHOD_POST, server1.Cred(), &replicator, nil)
+			// replace old replication record with new one
+			cluster.WriteReplicator(server1, db, peers[j], cluster.Password)
 		}
 	}
 	//DebugLog("finished replication configuration")
+	cluster.Operation.StepSucceeded(STEP_REPLICATION_CONFIGURED)
+	// replication is configured for current pods, reconciler does not have to do it again
+	RECONCILER.MarkSynced(cluster)
 
 	// no errors
 	return nil
 }
 
+// write continuous replication of database from server to target pod into "_replicator" db
+// old replication record of this database is deleted first
+// @param server *couch.Server - source server, replication is configured there
+// @param db string - database to replicate
+// @param targetHost string - ip or hostname of target pod
+// @param password string - admin password of target pod
+// @return error
+func (cluster *CouchdbCluster) WriteReplicator(server *couch.Server, db string, targetHost string, password string) (error) {
+	// replication struct, target url contains admin credentials of target pod
+	replicator := CouchdbReplicator{Id:"replicate_"+db,
+				Continuous: true, Source:server.Database(db).URL(),
+				Target:"http://"+cluster.Username+":"+password+"@"+targetHost+":"+COUCHDB_PORT_STRING+"/"+db}
+
+	// delete old replication, if any found
+	// get old replicator record
+	oldReplicator := CouchdbReplicator{}
+	couch.Do(server.URL()+"/_replicator/" + "replicate_" + db , METHOD_GET, server.Cred(), nil, &oldReplicator)
+	// if valid replicator record found, delete it
+	if oldReplicator.Rev != "" {
+		server.Database("_replicator").Delete("replicate_" + db, oldReplicator.Rev)
+	}
+
+	// setup new replication in _replicator db
+	_, err := couch.Do(server.URL()+"/_replicator", METHOD_POST, server.Cred(), &replicator, nil)
+	return err
+}
+
+// change password of couchdb admin via "_config" api, change is applied immediately
+// @param server *couch.Server - server with current admin credentials
+// @param username string - admin username
+// @param password string - new password
+// @return error
+func SetAdminPassword(server *couch.Server, username string, password string) (error) {
+	// value of config option is json string
+	_, err := couch.Do(server.URL()+"/_config/admins/"+username, METHOD_PUT, server.Cred(), password, nil)
+	return err
+}
+
+// change admin password on all pods of cluster and rewrite replication records with new password
+// pods are changed one by one, right after pod password is changed, replication records
+// of previous pod (which replicates to changed pod) are rewritten, so replication fails only for short moment
+// and continues from its last checkpoint
+// if any pod fails, already changed pods are switched back to old password
+// @param cluster *CouchdbCluster - required: cluster.Password is current admin password
+// @param newPassword string
+// @return error
+func (cluster *CouchdbCluster) RotateAdminPassword(newPassword string) (error) {
+	// get all pods
+	peers, err := cluster.PeerEndpoints()
+	if err != nil {
+		ErrorLog("couchdb_control: RotateAdminPassword: get peer endpoints error")
+		return err
+	}
+	// databases with replication records
+	databases, err := cluster.DatabasesToReplicate()
+	if err != nil {
+		ErrorLog("couchdb_control: RotateAdminPassword: load replicated databases error")
+		return err
+	}
+	// current password of each pod
+	passwords := make([]string, len(peers))
+	for i := range passwords {
+		passwords[i] = cluster.Password
+	}
+
+	cluster.Operation.StepRunning(STEP_PASSWORD_CHANGED)
+	err = cluster.changePodPasswords(peers, passwords, newPassword, databases)
+	if err != nil {
+		ErrorLog("couchdb_control: RotateAdminPassword: change password error, switching pods back to old password")
+		ErrorLog(err)
+		// rollback, pods with old password are skipped
+		if rollbackErr := cluster.changePodPasswords(peers, passwords, cluster.Password, databases); rollbackErr != nil {
+			ErrorLog("couchdb_control: RotateAdminPassword: rollback error")
+			ErrorLog(rollbackErr)
+		}
+		return err
+	}
+	cluster.Operation.StepSucceeded(STEP_PASSWORD_CHANGED)
+	cluster.Password = newPassword
+	return nil
+}
+
+// change admin password to newPassword on all pods which have other password
+// @param peers []string - pod addresses, in order of replication circle
+// @param passwords []string - current password of each pod, updated after each change
+// @param newPassword string
+// @param databases []string - replicated databases
+// @return error
+func (cluster *CouchdbCluster) changePodPasswords(peers []string, passwords []string, newPassword string, databases []string) (error) {
+	for j := range peers {
+		if passwords[j] == newPassword {
+			continue
+		}
+		DebugLog("couchdb_control: change password: pod: "+peers[j])
+		server := couch.NewServer("http://"+peers[j]+":"+COUCHDB_PORT_STRING,
+						couch.NewCredentials(cluster.Username, passwords[j]))
+		if err := CheckServer(server, MAX_RETRIES, RETRY_WAIT_TIME); err != nil {
+			ErrorLog("couchdb_control: change password: failed to connect to server, pod:"+peers[j])
+			return err
+		}
+		if err := SetAdminPassword(server, cluster.Username, newPassword); err != nil {
+			ErrorLog("couchdb_control: change password: _config error, pod:"+peers[j])
+			return err
+		}
+		passwords[j] = newPassword
+
+		// single pod does not replicate
+		if len(peers) < 2 {
+			continue
+		}
+		// previous pod replicates to this pod, rewrite its replication records
+		i := (j - 1 + len(peers)) % len(peers)
+		source := couch.NewServer("http://"+peers[i]+":"+COUCHDB_PORT_STRING,
+						couch.NewCredentials(cluster.Username, passwords[i]))
+		for _, db := range databases {
+			if db == "_users" {
+				// _users is not replicated via _replicator, see SetupReplication
+				continue
+			}
+			if err := cluster.WriteReplicator(source, db, peers[j], newPassword); err != nil {
+				ErrorLog("couchdb_control: change password: rewrite replication error, pod:"+peers[i]+", db: "+db)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setup replication for databases and add them to replication set of cluster
+// replica count is loaded from kubernetes
+// @param databases []string - databases to replicate
+// @return error
+func (cluster *CouchdbCluster) ReplicateDatabases(databases []string) (error) {
+	// get replica number
+	err := cluster.LoadReplicas()
+	if err != nil {
+		ErrorLog("couchdb_control: ReplicateDatabases: load replicas error")
+		return err
+	}
+	// setup replication for specified databases
+	err = cluster.SetupReplication(databases)
+	if err != nil {
+		ErrorLog("couchdb_control: ReplicateDatabases: setup replication error")
+		return err
+	}
+	// save requested dbs to persistent storage for this cluster, so we can reconfigure replication later
+	err = cluster.SaveReplDatabases(databases)
+	if err != nil {
+		ErrorLog("couchdb_control: ReplicateDatabases: save databases error")
+		return err
+	}
+	// replication set after change
+	cluster.Databases, err = cluster.DatabasesToReplicate()
+	return err
+}
+
 // create admin user after couchdb creation
 // @param pod - api.Pod - pdo where create admin user
 // NOT USED
@@ -176,49 +327,67 @@ func CheckServer(server *couch.Server, max_retries int, wait_time int) (error) {
 	}
 }
 
-// check if all pods are in running state, if not it will wait for them
-// (unless max retry count is reached)
-// before we can configure replication, we have to be sure, that all pods are in running state
+// check if all pods are ready (couchdb answers readiness probe), if not it will wait for them
+// (unless POD_READY_TIMEOUT expires)
+// before we can configure replication, we have to be sure, that all pods are ready
 // @param cluster *CouchdbCluster -
-// @return error
+// @return error - *WaitTimeoutError with pods that are not ready
 func (cluster *CouchdbCluster) CheckAllCouchdbPods() (error) {
-	var podList * []api.Pod
-	var err error
-	retries := MAX_RETRIES
-	for ;; {
-		// get pods for this cluster
-		podList, err = cluster.GetPods()
-		if err != nil {
-			ErrorLog("couchdb_control: setup replication - get pods error")
-			ErrorLog(err)
-			return err
-		}
-		// check if all pods are already spawned
-		if len(*podList) == int(cluster.Replicas) {
-			ok := true
-			// check if all pods are in state running
-			for _, pod := range *podList {
-				if pod.Status.Phase != api.PodRunning {
-					// pod is not ready yet
-					ok = false
-					break
-				}
+	err := cluster.WaitForPodsReady(POD_READY_TIMEOUT)
+	if err != nil {
+		ErrorLog("couchdb_control: CheckAllCouchdbPods: pods are not ready")
+		ErrorLog(err)
+		return err
+	}
+	return nil
+}
+// how often documents of seeded replica are compared with its source
+const RESEED_CHECK_INTERVAL = 5 * time.Second
+
+// response of couchdb database info
+type CouchdbDatabaseInfo struct {
+	DocCount int64 `json:"doc_count"`
+}
+
+// wait until target pod has at least as many documents as source pod in every replicated database
+// used when pod got new empty volume and replication copies data to it
+// @param source string - address of pod that replicates to target
+// @param target string - address of seeded pod
+// @param databases []string - replicated databases
+// @param timeout time.Duration
+// @return error - *WaitTimeoutError with databases that are not replicated yet
+func (cluster *CouchdbCluster) WaitForReplicaSeeded(source string, target string, databases []string, timeout time.Duration) (error) {
+	credentials := couch.NewCredentials(cluster.Username, cluster.Password)
+	sourceServer := couch.NewServer("http://"+source+":"+COUCHDB_PORT_STRING, credentials)
+	targetServer := couch.NewServer("http://"+target+":"+COUCHDB_PORT_STRING, credentials)
+	deadline := time.Now().Add(timeout)
+	for {
+		pending := []string{}
+		for _, db := range databases {
+			if db == "_users" {
+				// _users is not replicated, see SetupReplication
+				continue
 			}
-			// if we got all pods and all pods are running, then stop waiting and continue with replication
-			if ok {
-				//DebugLog("All Pods are ready")
-				break
+			sourceInfo := CouchdbDatabaseInfo{}
+			_, err := couch.Do(sourceServer.Database(db).URL(), METHOD_GET, sourceServer.Cred(), nil, &sourceInfo)
+			if err != nil {
+				ErrorLog("couchdb_control: WaitForReplicaSeeded: cannot get database info from source: "+db)
+				return err
+			}
+			// target database can be missing until replication creates it
+			targetInfo := CouchdbDatabaseInfo{}
+			_, err = couch.Do(targetServer.Database(db).URL(), METHOD_GET, targetServer.Cred(), nil, &targetInfo)
+			if err != nil || targetInfo.DocCount < sourceInfo.DocCount {
+				pending = append(pending, db+" ("+strconv.FormatInt(targetInfo.DocCount, 10)+"/"+strconv.FormatInt(sourceInfo.DocCount, 10)+" docs)")
 			}
-		} else if retries <= 0 {
-			errors.New("couchdb_control: setup_replication: waited too long for containers state")
-			ErrorLog(err)
-			return err
-			break
-		} else {
-			// wait for all pods
-			time.Sleep(time.Millisecond*RETRY_WAIT_TIME)
-			retries--
 		}
+		if len(pending) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			ErrorLog("couchdb_control: WaitForReplicaSeeded: timeout waiting for replica "+target)
+			return &WaitTimeoutError{What: "replica "+target+" seeded", Pending: pending}
+		}
+		time.Sleep(RESEED_CHECK_INTERVAL)
 	}
-	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,268 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for couchdb admin credentials
+// every cluster has its own generated admin password stored in kubernetes secret,
+// pods get it via secretKeyRef so it is never visible in pod spec
+// password can be rotated, see RotateCredentials
+package kanto
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+
+	"k8s.io/kubernetes/pkg/api"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+)
+
+const (
+	SECRET_SUFFIX       = "-admin"
+	SECRET_KEY_USERNAME = "username"
+	SECRET_KEY_PASSWORD = "password"
+	PASSWORD_LENGTH     = 24
+)
+
+// chars for generated passwords
+var passwordRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+
+// generate random password using crypto random generator
+// password contains only letters and numbers, so it can be used in replication url without escaping
+// @return string - generated password
+// @return error
+func GeneratePassword() (string, error) {
+	b := make([]rune, PASSWORD_LENGTH)
+	max := big.NewInt(int64(len(passwordRunes)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = passwordRunes[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// name of secret with admin credentials for cluster
+func (cluster *CouchdbCluster) SecretName() string {
+	return CLUSTER_PREFIX + cluster.Tag + SECRET_SUFFIX
+}
+
+// create secret with admin username and cluster.Password
+// @param cluster *CouchdbCluster - required: tag, username, password, labels, namespace
+// @return *api.Secret - created secret
+// @return error
+func (cluster *CouchdbCluster) CreateCredentialsSecret() (*api.Secret, error) {
+	secret := api.Secret{Type: api.SecretTypeOpaque}
+	secret.Name = cluster.SecretName()
+	secret.Labels = cluster.Labels
+	secret.Data = map[string][]byte{
+		SECRET_KEY_USERNAME: []byte(cluster.Username),
+		SECRET_KEY_PASSWORD: []byte(cluster.Password),
+	}
+	// get kube client
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("credentials: CreateCredentialsSecret: Cannot connect to Kubernetes api ")
+		ErrorLog(err)
+		return nil, err
+	}
+	return c.Secrets(cluster.Namespace).Create(&secret)
+}
+
+// load admin password from cluster secret to cluster.Password
+// clusters created before secrets were used do not have secret, their password is kept unchanged
+// @param cluster *CouchdbCluster - required: tag, namespace
+// @return error
+func (cluster *CouchdbCluster) LoadCredentials() error {
+	// get kube client
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("credentials: LoadCredentials: Cannot connect to Kubernetes api ")
+		ErrorLog(err)
+		return err
+	}
+	secret, err := c.Secrets(cluster.Namespace).Get(cluster.SecretName())
+	if apierrors.IsNotFound(err) {
+		// old cluster, password is user token
+		DebugLog("credentials: LoadCredentials: no secret for cluster " + cluster.Tag + ", using old credentials")
+		return nil
+	} else if err != nil {
+		ErrorLog("credentials: LoadCredentials: get secret error")
+		ErrorLog(err)
+		return err
+	}
+	cluster.Password = string(secret.Data[SECRET_KEY_PASSWORD])
+	return nil
+}
+
+// delete cluster secret, missing secret is not an error
+// @param cluster *CouchdbCluster - required: tag, namespace
+// @return error
+func (cluster *CouchdbCluster) DeleteCredentialsSecret() error {
+	// get kube client
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("credentials: DeleteCredentialsSecret: Cannot connect to Kubernetes api ")
+		ErrorLog(err)
+		return err
+	}
+	err = c.Secrets(cluster.Namespace).Delete(cluster.SecretName())
+	if err != nil && !apierrors.IsNotFound(err) {
+		ErrorLog("credentials: DeleteCredentialsSecret: delete secret error")
+		return err
+	}
+	return nil
+}
+
+// environment variable for pod, value is read from cluster secret
+// @param name string - env name
+// @param key string - key in secret
+// @return api.EnvVar
+func (cluster *CouchdbCluster) secretEnvVar(name string, key string) api.EnvVar {
+	selector := api.SecretKeySelector{LocalObjectReference: api.LocalObjectReference{Name: cluster.SecretName()}, Key: key}
+	return api.EnvVar{Name: name, ValueFrom: &api.EnvVarSource{SecretKeyRef: &selector}}
+}
+
+// save cluster.Password to cluster secret, secret is created if it does not exist (old clusters)
+// @param cluster *CouchdbCluster - required: tag, username, password, labels, namespace
+// @return error
+func (cluster *CouchdbCluster) UpdateCredentialsSecret() error {
+	// get kube client
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("credentials: UpdateCredentialsSecret: Cannot connect to Kubernetes api ")
+		ErrorLog(err)
+		return err
+	}
+	secret, err := c.Secrets(cluster.Namespace).Get(cluster.SecretName())
+	if apierrors.IsNotFound(err) {
+		_, err = cluster.CreateCredentialsSecret()
+		return err
+	} else if err != nil {
+		ErrorLog("credentials: UpdateCredentialsSecret: get secret error")
+		ErrorLog(err)
+		return err
+	}
+	secret.Data[SECRET_KEY_PASSWORD] = []byte(cluster.Password)
+	_, err = c.Secrets(cluster.Namespace).Update(secret)
+	return err
+}
+
+// rotate couchdb admin password of cluster
+// new password is set on all pods via couchdb "_config" api and replication records are rewritten,
+// then it is saved to cluster secret and pod templates of spawner are switched to the secret (old clusters)
+// if secret cannot be saved, pods are switched back to old password, which is still in secret
+// running pods are not restarted
+// @param cluster *CouchdbCluster - required: tag, username, labels, namespace, password (used when cluster has no secret)
+// @return error
+func (cluster *CouchdbCluster) RotateCredentials() error {
+	// wait for all pods
+	cluster.Operation.StepRunning(STEP_PODS_READY)
+	err := cluster.LoadReplicas()
+	if err != nil {
+		ErrorLog("credentials: RotateCredentials: load replicas error")
+		return err
+	}
+	err = cluster.CheckAllCouchdbPods()
+	if err != nil {
+		ErrorLog("credentials: RotateCredentials: check all pods error")
+		return err
+	}
+	cluster.Operation.StepSucceeded(STEP_PODS_READY)
+
+	// current password
+	err = cluster.LoadCredentials()
+	if err != nil {
+		ErrorLog("credentials: RotateCredentials: load credentials error")
+		return err
+	}
+	oldPassword := cluster.Password
+	password, err := GeneratePassword()
+	if err != nil {
+		ErrorLog("credentials: RotateCredentials: generate password error")
+		return err
+	}
+	// change password in couchdb
+	err = cluster.RotateAdminPassword(password)
+	if err != nil {
+		ErrorLog("credentials: RotateCredentials: rotate password error")
+		return err
+	}
+
+	// new pods have to start with new password
+	cluster.Operation.StepRunning(STEP_CREDENTIALS_UPDATED)
+	err = cluster.UpdateCredentialsSecret()
+	if err != nil {
+		ErrorLog("credentials: RotateCredentials: update secret error")
+		ErrorLog(err)
+		// new password would be lost, cluster has to stay reachable with credentials from secret
+		if rollbackErr := cluster.RotateAdminPassword(oldPassword); rollbackErr != nil {
+			ErrorLog("credentials: RotateCredentials: switch pods back to old password error")
+			ErrorLog(rollbackErr)
+			return errors.New(err.Error() + "; pods were not switched back to old password: " + rollbackErr.Error())
+		}
+		return err
+	}
+	err = cluster.UpdateSpawnerCredentials()
+	if err != nil {
+		ErrorLog("credentials: RotateCredentials: update pod template error")
+		return err
+	}
+	cluster.Operation.StepSucceeded(STEP_CREDENTIALS_UPDATED)
+	return nil
+}
+
+// switch admin credentials env of pod template to cluster secret
+// clusters created before secrets had username and token as plain env values
+// @param template *api.PodTemplateSpec
+// @return bool - true if template was changed
+func (cluster *CouchdbCluster) useSecretInPodTemplate(template *api.PodTemplateSpec) bool {
+	changed := false
+	for i := range template.Spec.Containers {
+		env := template.Spec.Containers[i].Env
+		for j := range env {
+			if env[j].ValueFrom != nil {
+				continue
+			}
+			if env[j].Name == "COUCHDB_USER" {
+				env[j] = cluster.secretEnvVar(env[j].Name, SECRET_KEY_USERNAME)
+				changed = true
+			} else if env[j].Name == "COUCHDB_PASSWORD" {
+				env[j] = cluster.secretEnvVar(env[j].Name, SECRET_KEY_PASSWORD)
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// switch pod templates of cluster spawner to cluster secret, templates already using secret are not updated
+// replication controllers keep their pods, deployment starts rolling update and replication is reconfigured
+// @param cluster *CouchdbCluster - required: tag, labels, namespace
+// @return error
+func (cluster *CouchdbCluster) UpdateSpawnerCredentials() error {
+	spawner, err := cluster.Spawner()
+	if err != nil {
+		return err
+	}
+	updater, ok := spawner.(PodTemplateUpdater)
+	if !ok {
+		// spawner was created with secret
+		return nil
+	}
+	replaced, err := updater.UpdatePodTemplate(cluster, cluster.useSecretInPodTemplate)
+	if err != nil {
+		ErrorLog("credentials: UpdateSpawnerCredentials: update pod template error")
+		return err
+	}
+	if replaced && cluster.Replicas > 1 {
+		// pods are replaced, they start with password from secret
+		databases, err := cluster.DatabasesToReplicate()
+		if err != nil {
+			return err
+		}
+		return cluster.SetupReplication(databases)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for pod disruption budget of couchdb clusters
+// budget keeps all but one replica available, so node drains evict replicas of a cluster one at a time,
+// clusters with one replica have no budget, their only pod could never be evicted
+package kanto
+
+import (
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/policy"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+// "false" disables pod disruption budgets, can be overwritten by os ENV "DISRUPTION_BUDGET"
+// kubernetes without policy api cannot create budgets
+var DISRUPTION_BUDGET = true
+
+// name of cluster pod disruption budget
+// @return string
+func (cluster *CouchdbCluster) DisruptionBudgetName() string {
+	return CLUSTER_PREFIX + cluster.Tag
+}
+
+// create, replace or delete cluster pod disruption budget to match cluster.Replicas
+// budget spec cannot be updated in kubernetes 1.3, so budget with other min available is replaced
+// @param cluster *CouchdbCluster - required: tag, labels, namespace, replicas
+// @return bool - budget was created (or replaced), false when budgets are disabled, deleted or already in step
+// @return error
+func (cluster *CouchdbCluster) SyncDisruptionBudget() (bool, error) {
+	if !DISRUPTION_BUDGET {
+		return false, nil
+	}
+	if cluster.Replicas < 2 {
+		return false, cluster.DeleteDisruptionBudget()
+	}
+	c, err := KubeClientPolicy(KUBE_API)
+	if err != nil {
+		ErrorLog("disruption: SyncDisruptionBudget: Cannot connect to Kubernetes api ")
+		return false, err
+	}
+	minAvailable := intstr.FromInt(int(cluster.Replicas) - 1)
+	budget, err := c.PodDisruptionBudgets(cluster.Namespace).Get(cluster.DisruptionBudgetName())
+	if err == nil && budget.Spec.MinAvailable == minAvailable {
+		// budget is in step with replicas
+		return false, nil
+	} else if err == nil {
+		err = cluster.DeleteDisruptionBudget()
+		if err != nil {
+			return false, err
+		}
+	} else if !apierrors.IsNotFound(err) {
+		ErrorLog("disruption: SyncDisruptionBudget: get pod disruption budget error")
+		return false, err
+	}
+
+	budget = &policy.PodDisruptionBudget{}
+	budget.Name = cluster.DisruptionBudgetName()
+	budget.Labels = cluster.Labels
+	budget.Spec.MinAvailable = minAvailable
+	budget.Spec.Selector = &unversioned.LabelSelector{MatchLabels: cluster.Labels}
+	_, err = c.PodDisruptionBudgets(cluster.Namespace).Create(budget)
+	if err != nil {
+		ErrorLog("disruption: SyncDisruptionBudget: create pod disruption budget error")
+		return false, err
+	}
+	return true, nil
+}
+
+// delete cluster pod disruption budget, cluster without budget is not an error
+// kubernetes without policy api has no budgets, so nothing is deleted when budgets are disabled
+// @param cluster *CouchdbCluster - required: tag, namespace
+// @return error
+func (cluster *CouchdbCluster) DeleteDisruptionBudget() error {
+	if !DISRUPTION_BUDGET {
+		return nil
+	}
+	c, err := KubeClientPolicy(KUBE_API)
+	if err != nil {
+		ErrorLog("disruption: DeleteDisruptionBudget: Cannot connect to Kubernetes api ")
+		return err
+	}
+	err = c.PodDisruptionBudgets(cluster.Namespace).Delete(cluster.DisruptionBudgetName(), nil)
+	if err != nil && !apierrors.IsNotFound(err) {
+		ErrorLog("disruption: DeleteDisruptionBudget: delete pod disruption budget error")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for exposing couchdb clusters outside of kubernetes
+// exposure is chosen by user on create from types allowed by operator and saved in cluster service annotation,
+// cluster endpoint is computed from service, so it is the url reachable by users
+package kanto
+
+import (
+	"errors"
+	"strconv"
+
+	"k8s.io/kubernetes/pkg/api"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+const (
+	// service reachable only inside kubernetes via cluster ip
+	EXPOSURE_INTERNAL = "internal"
+	// service port is opened on every node
+	EXPOSURE_NODEPORT = "nodeport"
+	// cloud provider creates load balancer for service
+	EXPOSURE_LOADBALANCER = "loadbalancer"
+	// ingress with generated hostname routes to service
+	EXPOSURE_INGRESS = "ingress"
+
+	// annotation of cluster service with exposure of cluster
+	ANNOTATION_EXPOSURE = "kanto/exposure"
+	// annotation of cluster service with hostname of cluster ingress
+	ANNOTATION_INGRESS_HOST = "kanto/ingress-host"
+	// annotation of ingress with ingress class, used when INGRESS_CLASS is set
+	ANNOTATION_INGRESS_CLASS = "kubernetes.io/ingress.class"
+)
+
+// exposure types users can choose, can be overwritten by os ENV "EXPOSURE_TYPES"
+var EXPOSURE_TYPES = []string{EXPOSURE_INTERNAL}
+
+// exposure of clusters created without exposure, can be overwritten by os ENV "EXPOSURE_DEFAULT"
+var DEFAULT_EXPOSURE = EXPOSURE_INTERNAL
+
+// host (ip or dns name) of kubernetes nodes reachable by users, used in endpoint of nodeport clusters,
+// set in main.go from os ENV "NODE_HOST"
+var NODE_HOST = ""
+
+// domain of generated ingress hostnames {tag}-{namespace}.{INGRESS_DOMAIN}, set in main.go from os ENV "INGRESS_DOMAIN"
+// wildcard dns record of domain has to point to ingress controller
+var INGRESS_DOMAIN = ""
+
+// ingress class of created ingresses, empty means default ingress controller, set in main.go from os ENV "INGRESS_CLASS"
+var INGRESS_CLASS = ""
+
+// check that exposure is known and allowed by operator
+// @param exposure string
+// @return error - message for user
+func ValidateExposure(exposure string) error {
+	switch exposure {
+	case EXPOSURE_INTERNAL, EXPOSURE_NODEPORT, EXPOSURE_LOADBALANCER, EXPOSURE_INGRESS:
+	default:
+		return errors.New("unknown exposure " + exposure + ", exposure has to be one of internal, nodeport, loadbalancer, ingress")
+	}
+	for _, allowed := range EXPOSURE_TYPES {
+		if allowed == exposure {
+			return nil
+		}
+	}
+	return errors.New("exposure " + exposure + " is not allowed")
+}
+
+// exposure saved in cluster service annotation
+// @param svc *api.Service - cluster service, can be nil
+// @return string - EXPOSURE_INTERNAL if service has no annotation (clusters created before exposure was configurable)
+func ExposureOf(svc *api.Service) string {
+	if svc == nil || svc.Annotations[ANNOTATION_EXPOSURE] == "" {
+		return EXPOSURE_INTERNAL
+	}
+	return svc.Annotations[ANNOTATION_EXPOSURE]
+}
+
+// couchdb url of cluster reachable by users
+// load balancer address is assigned by cloud provider after service is created, endpoint is empty until then
+// @param svc *api.Service - cluster service
+// @return string - empty if external address is not known yet
+func ServiceEndpoint(svc *api.Service) string {
+	switch ExposureOf(svc) {
+	case EXPOSURE_NODEPORT:
+		if NODE_HOST == "" || len(svc.Spec.Ports) == 0 || svc.Spec.Ports[0].NodePort == 0 {
+			return ""
+		}
+		return "http://" + NODE_HOST + ":" + strconv.Itoa(int(svc.Spec.Ports[0].NodePort))
+	case EXPOSURE_LOADBALANCER:
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				return ClusterEndpoint(ingress.IP)
+			} else if ingress.Hostname != "" {
+				return ClusterEndpoint(ingress.Hostname)
+			}
+		}
+		return ""
+	case EXPOSURE_INGRESS:
+		if svc.Annotations[ANNOTATION_INGRESS_HOST] == "" {
+			return ""
+		}
+		return "http://" + svc.Annotations[ANNOTATION_INGRESS_HOST]
+	}
+	return ClusterEndpoint(svc.Spec.ClusterIP)
+}
+
+// kubernetes service type for cluster exposure
+// ingress controller routes to cluster ip, so ingress clusters have ClusterIP service
+// @return api.ServiceType
+func (cluster *CouchdbCluster) serviceType() api.ServiceType {
+	switch cluster.Exposure {
+	case EXPOSURE_NODEPORT:
+		return api.ServiceTypeNodePort
+	case EXPOSURE_LOADBALANCER:
+		return api.ServiceTypeLoadBalancer
+	}
+	return api.ServiceTypeClusterIP
+}
+
+// generated hostname of cluster ingress
+// @return string
+func (cluster *CouchdbCluster) IngressHost() string {
+	return cluster.Tag + "-" + cluster.Namespace + "." + INGRESS_DOMAIN
+}
+
+// create ingress that routes cluster hostname to cluster service
+// @param cluster *CouchdbCluster - required: tag, labels, namespace
+// @return *extensions.Ingress - created ingress
+// @return error
+func (cluster *CouchdbCluster) CreateClusterIngress() (*extensions.Ingress, error) {
+	backend := extensions.IngressBackend{ServiceName: CLUSTER_PREFIX + cluster.Tag, ServicePort: intstr.FromInt(COUCHDB_PORT)}
+	rule := extensions.IngressRule{Host: cluster.IngressHost()}
+	rule.HTTP = &extensions.HTTPIngressRuleValue{Paths: []extensions.HTTPIngressPath{{Path: "/", Backend: backend}}}
+	ingress := extensions.Ingress{Spec: extensions.IngressSpec{Rules: []extensions.IngressRule{rule}}}
+	ingress.Name = CLUSTER_PREFIX + cluster.Tag
+	ingress.Labels = cluster.Labels
+	if INGRESS_CLASS != "" {
+		ingress.Annotations = map[string]string{ANNOTATION_INGRESS_CLASS: INGRESS_CLASS}
+	}
+	c, err := KubeClientExtensions(KUBE_API)
+	if err != nil {
+		ErrorLog("exposure: CreateClusterIngress: Cannot connect to Kubernetes api ")
+		return nil, err
+	}
+	return c.Ingress(cluster.Namespace).Create(&ingress)
+}
+
+// delete cluster ingress, cluster without ingress is not an error
+// @param cluster *CouchdbCluster - required: tag, namespace
+// @return error
+func (cluster *CouchdbCluster) DeleteClusterIngress() error {
+	c, err := KubeClientExtensions(KUBE_API)
+	if err != nil {
+		ErrorLog("exposure: DeleteClusterIngress: Cannot connect to Kubernetes api ")
+		return err
+	}
+	err = c.Ingress(cluster.Namespace).Delete(CLUSTER_PREFIX+cluster.Tag, nil)
+	if err != nil && !apierrors.IsNotFound(err) {
+		ErrorLog("exposure: DeleteClusterIngress: delete ingress error")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,435 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for shared kubernetes cache
+// services, pods, deployments, replication controllers, claims and pet sets of all clusters are kept
+// in memory by informers (list + watch), list and detail requests are answered from this cache
+// changes are always done via api, cache is used only for reading,
+// operations read from api too, cache can lag behind objects they just created or changed
+package kanto
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/meta"
+	"k8s.io/kubernetes/pkg/apis/apps"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/controller/framework"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+const (
+	// index of cached objects by cluster, key is "namespace/user/tag"
+	CACHE_INDEX_CLUSTER = "cluster"
+	// index of cached objects by user, key is "namespace/user"
+	CACHE_INDEX_USER = "user"
+	// all cached objects are sent to listeners again after this period
+	CACHE_RESYNC_PERIOD = 10 * time.Minute
+)
+
+// running cache, nil when cache is disabled (os ENV "KUBE_CACHE=false")
+var KUBE_CACHE *KubeCache
+
+// cache for reading objects of cluster
+// @return *KubeCache - nil (no cache) if cluster is not read only (cluster.ReadCache) or cache is disabled
+func (cluster *CouchdbCluster) cache() *KubeCache {
+	if !cluster.ReadCache {
+		return nil
+	}
+	return KUBE_CACHE
+}
+
+// one cached resource type
+type cachedResource struct {
+	indexer    cache.Indexer
+	controller *framework.Controller
+}
+
+// cache is usable only after first list
+func (r *cachedResource) synced() bool {
+	return r != nil && r.controller.HasSynced()
+}
+
+// objects of cluster, filtered by all cluster labels
+// @return []interface{} - cached objects, do not modify them
+// @return bool - false if cache is not synced yet
+func (r *cachedResource) byCluster(cluster *CouchdbCluster) ([]interface{}, bool) {
+	if !r.synced() {
+		return nil, false
+	}
+	items, err := r.indexer.ByIndex(CACHE_INDEX_CLUSTER, reconcileKey(cluster.Namespace, cluster.Username, cluster.Tag))
+	if err != nil {
+		return nil, false
+	}
+	selector := labels.SelectorFromSet(labels.Set(cluster.Labels))
+	result := []interface{}{}
+	for _, item := range items {
+		if obj, ok := item.(runtime.Object); ok && matchesSelector(obj, selector) {
+			result = append(result, item)
+		}
+	}
+	return result, true
+}
+
+// object by namespace and name
+// @return interface{} - cached object, nil if object is not in cache
+// @return bool - false if cache is not synced yet
+func (r *cachedResource) get(namespace string, name string) (interface{}, bool) {
+	if !r.synced() {
+		return nil, false
+	}
+	item, exists, err := r.indexer.GetByKey(namespace + "/" + name)
+	if err != nil {
+		return nil, false
+	}
+	if !exists {
+		return nil, true
+	}
+	return item, true
+}
+
+// shared informers of kanto objects
+type KubeCache struct {
+	services               *cachedResource
+	pods                   *cachedResource
+	deployments            *cachedResource
+	replicationControllers *cachedResource
+	claims                 *cachedResource
+	petSets                *cachedResource
+
+	listenersMutex sync.RWMutex
+	listeners      []func(runtime.Object)
+}
+
+// create cache and start informers in background
+// objects with labels "user" and "cluster_tag" are cached from all namespaces
+// @return *KubeCache
+// @return error
+func StartKubeCache() (*KubeCache, error) {
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("kube_cache: Cannot connect to Kubernetes api ")
+		return nil, err
+	}
+	ce, err := KubeClientExtensions(KUBE_API)
+	if err != nil {
+		ErrorLog("kube_cache: Cannot connect to Kubernetes extensions api ")
+		return nil, err
+	}
+	ca, err := KubeClientApps(KUBE_API)
+	if err != nil {
+		ErrorLog("kube_cache: Cannot connect to Kubernetes apps api ")
+		return nil, err
+	}
+	selector, err := labels.Parse(LABEL_USER + "," + LABEL_CLUSTER_TAG)
+	if err != nil {
+		return nil, err
+	}
+	kc := &KubeCache{}
+	ns := api.NamespaceAll
+
+	kc.services = kc.informer(&api.Service{}, selector,
+		func(o api.ListOptions) (runtime.Object, error) { return c.Services(ns).List(o) },
+		func(o api.ListOptions) (watch.Interface, error) { return c.Services(ns).Watch(o) })
+	kc.pods = kc.informer(&api.Pod{}, selector,
+		func(o api.ListOptions) (runtime.Object, error) { return c.Pods(ns).List(o) },
+		func(o api.ListOptions) (watch.Interface, error) { return c.Pods(ns).Watch(o) })
+	kc.replicationControllers = kc.informer(&api.ReplicationController{}, selector,
+		func(o api.ListOptions) (runtime.Object, error) { return c.ReplicationControllers(ns).List(o) },
+		func(o api.ListOptions) (watch.Interface, error) { return c.ReplicationControllers(ns).Watch(o) })
+	kc.claims = kc.informer(&api.PersistentVolumeClaim{}, selector,
+		func(o api.ListOptions) (runtime.Object, error) { return c.PersistentVolumeClaims(ns).List(o) },
+		func(o api.ListOptions) (watch.Interface, error) { return c.PersistentVolumeClaims(ns).Watch(o) })
+	kc.deployments = kc.informer(&extensions.Deployment{}, selector,
+		func(o api.ListOptions) (runtime.Object, error) { return ce.Deployments(ns).List(o) },
+		func(o api.ListOptions) (watch.Interface, error) { return ce.Deployments(ns).Watch(o) })
+	// pet sets are alpha, informer is never synced when api server does not serve them
+	kc.petSets = kc.informer(&apps.PetSet{}, selector,
+		func(o api.ListOptions) (runtime.Object, error) { return ca.PetSets(ns).List(o) },
+		func(o api.ListOptions) (watch.Interface, error) { return ca.PetSets(ns).Watch(o) })
+
+	InfoLog("kube_cache: kubernetes cache started")
+	return kc, nil
+}
+
+// create and run informer for one resource type
+func (kc *KubeCache) informer(objType runtime.Object, selector labels.Selector,
+	list func(api.ListOptions) (runtime.Object, error), watchFrom func(api.ListOptions) (watch.Interface, error)) *cachedResource {
+	lw := &cache.ListWatch{
+		ListFunc: func(options api.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = selector
+			return list(options)
+		},
+		WatchFunc: func(options api.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selector
+			return watchFrom(options)
+		},
+	}
+	handlers := framework.ResourceEventHandlerFuncs{
+		AddFunc:    kc.notify,
+		UpdateFunc: func(old interface{}, obj interface{}) { kc.notify(obj) },
+		DeleteFunc: func(obj interface{}) {
+			if deleted, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = deleted.Obj
+			}
+			kc.notify(obj)
+		},
+	}
+	indexers := cache.Indexers{CACHE_INDEX_CLUSTER: clusterIndex, CACHE_INDEX_USER: userIndex}
+	indexer, controller := framework.NewIndexerInformer(lw, objType, CACHE_RESYNC_PERIOD, handlers, indexers)
+	go controller.Run(make(chan struct{}))
+	return &cachedResource{indexer: indexer, controller: controller}
+}
+
+// register function called for every added, changed and deleted object
+// objects already in cache are sent to listener immediately
+// safe to call on nil cache
+// @param listener func(runtime.Object)
+func (kc *KubeCache) AddListener(listener func(runtime.Object)) {
+	if kc == nil {
+		return
+	}
+	kc.listenersMutex.Lock()
+	defer kc.listenersMutex.Unlock()
+	kc.listeners = append(kc.listeners, listener)
+	for _, resource := range []*cachedResource{kc.services, kc.pods, kc.deployments, kc.replicationControllers, kc.claims, kc.petSets} {
+		for _, item := range resource.indexer.List() {
+			if obj, ok := item.(runtime.Object); ok {
+				listener(obj)
+			}
+		}
+	}
+}
+
+// send object to all listeners
+func (kc *KubeCache) notify(obj interface{}) {
+	object, ok := obj.(runtime.Object)
+	if !ok {
+		return
+	}
+	kc.listenersMutex.RLock()
+	defer kc.listenersMutex.RUnlock()
+	for _, listener := range kc.listeners {
+		listener(object)
+	}
+}
+
+// get cluster service from cache
+// safe to call on nil cache
+// @param cluster *CouchdbCluster - required: tag, username, namespace, labels
+// @return *api.Service - copy of service, nil if cluster does not exist
+// @return bool - false if cache cannot be used
+func (kc *KubeCache) ClusterService(cluster *CouchdbCluster) (*api.Service, bool) {
+	if kc == nil {
+		return nil, false
+	}
+	items, ok := kc.services.byCluster(cluster)
+	if !ok {
+		return nil, false
+	}
+	for _, item := range items {
+		if svc, ok := item.(*api.Service); ok && svc.Name == CLUSTER_PREFIX+cluster.Tag {
+			if copied, ok := copyObject(svc).(*api.Service); ok {
+				return copied, true
+			}
+			return nil, false
+		}
+	}
+	return nil, true
+}
+
+// get all services of user from cache
+// safe to call on nil cache
+// @param namespace string
+// @param username string
+// @return []api.Service - copies of services
+// @return bool - false if cache cannot be used
+func (kc *KubeCache) UserServices(namespace string, username string) ([]api.Service, bool) {
+	if kc == nil || !kc.services.synced() {
+		return nil, false
+	}
+	items, err := kc.services.indexer.ByIndex(CACHE_INDEX_USER, namespace+"/"+username)
+	if err != nil {
+		return nil, false
+	}
+	services := []api.Service{}
+	for _, item := range items {
+		if svc, ok := copyObject(item).(*api.Service); ok {
+			services = append(services, *svc)
+		}
+	}
+	return services, true
+}
+
+// get pods of cluster from cache
+// safe to call on nil cache
+// @param cluster *CouchdbCluster - required: tag, username, namespace, labels
+// @return []api.Pod - copies of pods
+// @return bool - false if cache cannot be used
+func (kc *KubeCache) ClusterPods(cluster *CouchdbCluster) ([]api.Pod, bool) {
+	if kc == nil {
+		return nil, false
+	}
+	items, ok := kc.pods.byCluster(cluster)
+	if !ok {
+		return nil, false
+	}
+	pods := []api.Pod{}
+	for _, item := range items {
+		if pod, ok := copyObject(item).(*api.Pod); ok {
+			pods = append(pods, *pod)
+		}
+	}
+	return pods, true
+}
+
+// get pod services of cluster from cache
+// safe to call on nil cache
+// @param cluster *CouchdbCluster - required: tag, username, namespace, labels
+// @return []api.Service - copies of pod services
+// @return bool - false if cache cannot be used
+func (kc *KubeCache) PodServices(cluster *CouchdbCluster) ([]api.Service, bool) {
+	if kc == nil {
+		return nil, false
+	}
+	items, ok := kc.services.byCluster(cluster)
+	if !ok {
+		return nil, false
+	}
+	services := []api.Service{}
+	for _, item := range items {
+		if svc, ok := copyObject(item).(*api.Service); ok && svc.Labels[LABEL_POD_SERVICE] != "" {
+			services = append(services, *svc)
+		}
+	}
+	return services, true
+}
+
+// get replication controllers of cluster from cache
+// safe to call on nil cache
+// @param cluster *CouchdbCluster - required: tag, username, namespace, labels
+// @return []api.ReplicationController - copies of replication controllers
+// @return bool - false if cache cannot be used
+func (kc *KubeCache) ReplicationControllers(cluster *CouchdbCluster) ([]api.ReplicationController, bool) {
+	if kc == nil {
+		return nil, false
+	}
+	items, ok := kc.replicationControllers.byCluster(cluster)
+	if !ok {
+		return nil, false
+	}
+	rcs := []api.ReplicationController{}
+	for _, item := range items {
+		if rc, ok := copyObject(item).(*api.ReplicationController); ok {
+			rcs = append(rcs, *rc)
+		}
+	}
+	return rcs, true
+}
+
+// get persistent volume claims of cluster from cache
+// safe to call on nil cache
+// @param cluster *CouchdbCluster - required: tag, username, namespace, labels
+// @return []api.PersistentVolumeClaim - copies of claims
+// @return bool - false if cache cannot be used
+func (kc *KubeCache) Claims(cluster *CouchdbCluster) ([]api.PersistentVolumeClaim, bool) {
+	if kc == nil {
+		return nil, false
+	}
+	items, ok := kc.claims.byCluster(cluster)
+	if !ok {
+		return nil, false
+	}
+	claims := []api.PersistentVolumeClaim{}
+	for _, item := range items {
+		if pvc, ok := copyObject(item).(*api.PersistentVolumeClaim); ok {
+			claims = append(claims, *pvc)
+		}
+	}
+	return claims, true
+}
+
+// get deployment from cache
+// safe to call on nil cache
+// @param namespace string
+// @param name string
+// @return *extensions.Deployment - copy of deployment, nil if deployment is not in cache
+// @return bool - false if cache cannot be used
+func (kc *KubeCache) Deployment(namespace string, name string) (*extensions.Deployment, bool) {
+	if kc == nil {
+		return nil, false
+	}
+	item, ok := kc.deployments.get(namespace, name)
+	if !ok || item == nil {
+		return nil, ok
+	}
+	deployment, ok := copyObject(item).(*extensions.Deployment)
+	return deployment, ok
+}
+
+// get pet set from cache
+// safe to call on nil cache
+// @param namespace string
+// @param name string
+// @return *apps.PetSet - copy of pet set, nil if pet set is not in cache
+// @return bool - false if cache cannot be used
+func (kc *KubeCache) PetSet(namespace string, name string) (*apps.PetSet, bool) {
+	if kc == nil {
+		return nil, false
+	}
+	item, ok := kc.petSets.get(namespace, name)
+	if !ok || item == nil {
+		return nil, ok
+	}
+	petSet, ok := copyObject(item).(*apps.PetSet)
+	return petSet, ok
+}
+
+// index function, cluster of object
+func clusterIndex(obj interface{}) ([]string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	objLabels := accessor.GetLabels()
+	return []string{reconcileKey(accessor.GetNamespace(), objLabels[LABEL_USER], objLabels[LABEL_CLUSTER_TAG])}, nil
+}
+
+// index function, user of object
+func userIndex(obj interface{}) ([]string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	return []string{accessor.GetNamespace() + "/" + accessor.GetLabels()[LABEL_USER]}, nil
+}
+
+// check object labels
+func matchesSelector(obj runtime.Object, selector labels.Selector) bool {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(accessor.GetLabels()))
+}
+
+// deep copy of cached object, cached objects are shared and must not be modified
+// @return runtime.Object - nil if object cannot be copied
+func copyObject(obj interface{}) runtime.Object {
+	object, ok := obj.(runtime.Object)
+	if !ok {
+		return nil
+	}
+	copied, err := api.Scheme.Copy(object)
+	if err != nil {
+		ErrorLog("kube_cache: cannot copy cached object")
+		ErrorLog(err)
+		return nil
+	}
+	return copied
+}
@@ -0,0 +1,106 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for kubernetes client configuration
+// kanto can connect to kubernetes api with service account (when running inside cluster),
+// kubeconfig file or explicit bearer token, client certificate and CA
+package kanto
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/client/restclient"
+	"k8s.io/kubernetes/pkg/client/unversioned/clientcmd"
+)
+
+// configuration of kubernetes client, set in main.go from os env
+// nil means unauthenticated connection to KUBE_API
+var KUBE_CONFIG *restclient.Config
+
+// how kanto authenticates to kubernetes api
+type KubeAuthOptions struct {
+	// api url, overrides url from kubeconfig
+	Host string
+	// use service account of kanto pod
+	InCluster bool
+	// path to kubeconfig file and context in it, empty context means current context
+	Kubeconfig string
+	Context    string
+	// bearer token or file with token
+	Token     string
+	TokenFile string
+	// client certificate and key
+	CertFile string
+	KeyFile  string
+	// CA used to verify api server certificate
+	CAFile string
+	// do not verify api server certificate, development only
+	Insecure bool
+}
+
+// build kubernetes client configuration
+// kubeconfig has priority, then in-cluster service account, then host with token and certificates
+// @param options KubeAuthOptions
+// @return *restclient.Config
+// @return error
+func LoadKubeConfig(options KubeAuthOptions) (*restclient.Config, error) {
+	// kubeconfig file
+	if options.Kubeconfig != "" {
+		rules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: options.Kubeconfig}
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: options.Context}
+		if options.Host != "" {
+			overrides.ClusterInfo.Server = options.Host
+		}
+		config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+		if err != nil {
+			ErrorLog("kube_config: cannot load kubeconfig " + options.Kubeconfig)
+			return nil, err
+		}
+		return config, nil
+	}
+
+	// service account token and CA mounted into kanto pod
+	if options.InCluster {
+		config, err := restclient.InClusterConfig()
+		if err != nil {
+			ErrorLog("kube_config: cannot load in-cluster configuration")
+			return nil, err
+		}
+		return config, nil
+	}
+
+	// explicit configuration
+	if options.Host == "" {
+		return nil, errors.New("kubernetes api url is not set")
+	}
+	config := &restclient.Config{Host: options.Host, BearerToken: options.Token}
+	if options.TokenFile != "" {
+		token, err := ioutil.ReadFile(options.TokenFile)
+		if err != nil {
+			ErrorLog("kube_config: cannot read token file " + options.TokenFile)
+			return nil, err
+		}
+		config.BearerToken = strings.TrimSpace(string(token))
+	}
+	if (options.CertFile == "") != (options.KeyFile == "") {
+		return nil, errors.New("client certificate and key have to be set together")
+	}
+	config.TLSClientConfig = restclient.TLSClientConfig{CertFile: options.CertFile, KeyFile: options.KeyFile,
+		CAFile: options.CAFile}
+	config.Insecure = options.Insecure
+	return config, nil
+}
+
+// copy of client configuration for host
+// @param host string - url for kubernetes API
+// @return *restclient.Config
+func KubeConfig(host string) *restclient.Config {
+	if KUBE_CONFIG == nil {
+		return &restclient.Config{Host: host}
+	}
+	config := *KUBE_CONFIG
+	config.Host = host
+	return &config
+}
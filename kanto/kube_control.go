@@ -9,15 +9,15 @@ package kanto
 import (
 	"errors"
 	"strings"
-	"time"
+	"sync"
 	// kubernetes imports
-	"k8s.io/kubernetes/pkg/client/restclient"
 	"k8s.io/kubernetes/pkg/api"
 	client "k8s.io/kubernetes/pkg/client/unversioned"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
 	"k8s.io/kubernetes/pkg/labels"
 )
 
-// default kube api,  can be overwritten by os ENV "KUBERNETES_API_URL"
+// default kube api,  can be overwritten by os ENV "KUBERNETES_API_URL" or by kubeconfig / in-cluster configuration
 var KUBE_API string = "http://127.0.0.1:8080"
 const (
 	COUCHDB_PORT = 5984
@@ -35,6 +35,7 @@ const (
 
 	DOCKER_IMAGE = "calvix/couchdb"
 	COUCHDB_VOLUME_MOUNTPATH = "/usr/local/var/lib/couchdb"
+	// default size of volume claim, can be overwritten by os ENV "STORAGE_DEFAULT_SIZE"
 	COUCHDB_VOLUME_SIZE = 5*1024*1024*1024 // 5GB
 
 
@@ -45,126 +46,336 @@ const (
 
 var SPAWNER_TYPE string = COMPONENT_DEPLOYMENT
 
-// create kubernetes api client
+// error returned when cluster service does not exist
+var ErrClusterNotFound = errors.New("service not found")
+
+// kubernetes api clients, one client per host is shared by all requests and operations
+// clients are created with KUBE_CONFIG, which is set only once at start
+var kubeClientsMutex sync.Mutex
+var kubeClients = make(map[string]*client.Client)
+var kubeClientsExtensions = make(map[string]*client.ExtensionsClient)
+var kubeClientsApps = make(map[string]*client.AppsClient)
+var kubeClientsPolicy = make(map[string]*client.PolicyClient)
+
+// get shared kubernetes api client
 // @param host string - url for kubernetes API
 // @return client - kubernetes api client
 // @return error
 func KubeClient(host string) (*client.Client, error) {
+	kubeClientsMutex.Lock()
+	defer kubeClientsMutex.Unlock()
+	if c, ok := kubeClients[host]; ok {
+		return c, nil
+	}
 	// create configuration for kube client
-	config :=  &restclient.Config{Host:host}
-	// return client and error
-	return client.New(config)
+	config := KubeConfig(host)
+	c, err := client.New(config)
+	if err != nil {
+		return nil, err
+	}
+	kubeClients[host] = c
+	return c, nil
 }
-// create kubernetes api client
+// get shared kubernetes extensions api client
 // @param host string - url for kubernetes API
 // @return client - kubernetes api client
 // @return error
 func KubeClientExtensions(host string) (*client.ExtensionsClient, error) {
+	kubeClientsMutex.Lock()
+	defer kubeClientsMutex.Unlock()
+	if c, ok := kubeClientsExtensions[host]; ok {
+		return c, nil
+	}
 	// create configuration for kube client
-	config :=  &restclient.Config{Host:host}
-	// return client and error
-	return client.NewExtensions(config)
+	config := KubeConfig(host)
+	c, err := client.NewExtensions(config)
+	if err != nil {
+		return nil, err
+	}
+	kubeClientsExtensions[host] = c
+	return c, nil
 }
-// create kubernetes api client
+// get shared kubernetes apps api client
 // @param host string - url for kubernetes API
 // @return client - kubernetes api client
 // @return error
 func KubeClientApps(host string) (*client.AppsClient, error) {
+	kubeClientsMutex.Lock()
+	defer kubeClientsMutex.Unlock()
+	if c, ok := kubeClientsApps[host]; ok {
+		return c, nil
+	}
 	// create configuration for kube client
-	config :=  &restclient.Config{Host:host}
-	// return client and error
-	return client.NewApps(config)
+	config := KubeConfig(host)
+	c, err := client.NewApps(config)
+	if err != nil {
+		return nil, err
+	}
+	kubeClientsApps[host] = c
+	return c, nil
+}
+// get shared kubernetes policy api client
+// @param host string - url for kubernetes API
+// @return client - kubernetes api client
+// @return error
+func KubeClientPolicy(host string) (*client.PolicyClient, error) {
+	kubeClientsMutex.Lock()
+	defer kubeClientsMutex.Unlock()
+	if c, ok := kubeClientsPolicy[host]; ok {
+		return c, nil
+	}
+	// create configuration for kube client
+	config := KubeConfig(host)
+	c, err := client.NewPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+	kubeClientsPolicy[host] = c
+	return c, nil
 }
 
 
 // create couchdb cluster and expose it
-// will create deployment and service components
+// will create credentials secret, deployment and service components
 // if cluster has more than 1 replica it will setup replication between all pods
-// @param cluster - CouchdbCluster struct - required: tag, username, replicas, labels
+// creation is transaction, if any step fails all created components are deleted in reverse order
+// tenant namespace is not deleted, it can be shared with other clusters
+// @param cluster - CouchdbCluster struct - required: tag, username, password, replicas, labels, namespace
+// @return error - *RollbackError with rollback result if creation failed
 //
 func (cluster *CouchdbCluster) CreateCouchdbCluster() (error){
-	// create pod spawner for cluster
-	var err error
-	if SPAWNER_TYPE == COMPONENT_DEPLOYMENT {
-		// deployment does nto work with persisten volumes
-		_, err = cluster.CreateDeployment()
-	} else if SPAWNER_TYPE == COMPONENT_RC {
-		// rc works with persistent volumes
-		err = cluster.CreateReplicationControllers()
-		// clear replica labels
-		delete(cluster.Labels, LABEL_REPLICA)
-	} else if SPAWNER_TYPE == COMPONENT_PETSET {
-		// create pet sets
-		_, err = cluster.CreatePetSet()
+	cluster.Transaction = &Transaction{}
+	defer func() { cluster.Transaction = nil }()
+
+	err := cluster.createCouchdbCluster()
+	if err != nil {
+		InfoLog("kube_control: CreateCouchdbCluster: creation failed, rolling back cluster_tag: "+cluster.Tag)
+		return cluster.Transaction.Rollback(err)
 	}
+	return nil
+}
 
+// create couchdb cluster, all created components are tracked in cluster.Transaction
+func (cluster *CouchdbCluster) createCouchdbCluster() (error){
+	// tenant namespace with quota, only when kanto uses namespace per user or team
+	if TENANTS.Mode == NAMESPACE_MODE_USER || TENANTS.Mode == NAMESPACE_MODE_TEAM {
+		cluster.Operation.StepRunning(STEP_NAMESPACE_READY)
+		_, err := TENANTS.EnsureNamespace(cluster.Username)
+		if err != nil {
+			ErrorLog("kube_control: CreateCouchdbCluster: tenant namespace fail")
+			return err
+		}
+		cluster.Operation.StepSucceeded(STEP_NAMESPACE_READY)
+	} else {
+		cluster.Operation.StepSkipped(STEP_NAMESPACE_READY)
+	}
+
+	// save admin credentials, pods read them from secret
+	cluster.Operation.StepRunning(STEP_CREDENTIALS_CREATED)
+	_, err := cluster.CreateCredentialsSecret()
+	if err != nil {
+		ErrorLog("kube_control: CreateCouchdbCluster: credentials secret creating fail")
+		ErrorLog(err)
+		return err
+	}
+	cluster.Transaction.Track("secret "+cluster.SecretName(), cluster.DeleteCredentialsSecret)
+	cluster.Operation.StepSucceeded(STEP_CREDENTIALS_CREATED)
+
+	// create pod spawner for cluster, spawner type is saved in cluster service
+	cluster.Operation.StepRunning(STEP_SPAWNER_CREATED)
+	if cluster.SpawnerType == "" {
+		cluster.SpawnerType = SPAWNER_TYPE
+	}
+	// pods are deleted after spawner, deleted spawner does not delete its pods
+	cluster.Transaction.Track("pods of cluster "+cluster.Tag, func() error {
+		if err := cluster.DeletePods(); err != nil {
+			return err
+		}
+		return cluster.WaitForPodsDeleted(DELETE_TIMEOUT)
+	})
+	spawner, err := cluster.Spawner()
+	if err == nil {
+		err = spawner.Create(cluster)
+	}
 	// check for errors
 	if err != nil {
 		ErrorLog("kube_control: CreateCouchdbCluster: deployment creating fail")
 		ErrorLog(err)
 		return err
 	}
+	cluster.Operation.StepSucceeded(STEP_SPAWNER_CREATED)
 
 	// expose couchdb via service
+	cluster.Operation.StepRunning(STEP_SERVICE_CREATED)
 	svc, err := cluster.CreateClusterService()
-	// save endpoint to struct
-	cluster.Endpoint = ClusterEndpoint(svc.Spec.ClusterIP)
 	// check for errors
 	if err != nil {
-		// TODO, if service creation fails, delete deployment
-
 		ErrorLog("kube_control: CreateCouchdbCluster: service expose creating fail")
 		ErrorLog(err)
 		return err
 	}
+	cluster.Transaction.Track("service "+svc.Name, cluster.DeleteClusterService)
+	// route generated hostname to service
+	if cluster.Exposure == EXPOSURE_INGRESS {
+		ingress, err := cluster.CreateClusterIngress()
+		if err != nil {
+			ErrorLog("kube_control: CreateCouchdbCluster: ingress creating fail")
+			ErrorLog(err)
+			return err
+		}
+		cluster.Transaction.Track("ingress "+ingress.Name, cluster.DeleteClusterIngress)
+	}
+	// node drains evict replicas one at a time
+	budgetCreated, err := cluster.SyncDisruptionBudget()
+	if err != nil {
+		ErrorLog("kube_control: CreateCouchdbCluster: pod disruption budget creating fail")
+		ErrorLog(err)
+		return err
+	}
+	// disabled budgets and clusters with one replica have no budget to roll back
+	if budgetCreated {
+		cluster.Transaction.Track("pod disruption budget "+cluster.DisruptionBudgetName(), cluster.DeleteDisruptionBudget)
+	}
+	// save endpoint to struct
+	cluster.Endpoint = ServiceEndpoint(svc)
+	cluster.Operation.StepSucceeded(STEP_SERVICE_CREATED)
 	// if required more than 1 replica, configure replication
 	if cluster.Replicas > 1 {
 		// setup replication for basic databases
-		cluster.SetupReplication(DatabasesToReplicate(cluster.Username))
+		databases, err := cluster.DatabasesToReplicate()
+		if err != nil {
+			ErrorLog("kube_control: CreateCouchdbCluster: load replicated databases fail")
+			return err
+		}
+		err = cluster.SetupReplication(databases)
+		if err != nil {
+			ErrorLog("kube_control: CreateCouchdbCluster: setup replication fail")
+			return err
+		}
 	} else {
 		DebugLog("kube_control: not setting replication, only 1 replica")
+		// wait for pod anyway, so finished operation means usable cluster
+		cluster.Operation.StepRunning(STEP_PODS_READY)
+		err = cluster.CheckAllCouchdbPods()
+		if err != nil {
+			ErrorLog("kube_control: CreateCouchdbCluster: check pods fail")
+			return err
+		}
+		cluster.Operation.StepSucceeded(STEP_PODS_READY)
+		cluster.Operation.StepSkipped(STEP_REPLICATION_CONFIGURED)
 	}
 	// no error
 	return  nil
 }
 
 // delete whole couch db cluster from kubernetes
-// function will delete deployment and service and then all orphaned components (cascade deleting does not work)
+// function will delete spawner and then all orphaned components (cascade deleting does not work)
+// cluster service is deleted last and only when everything else is deleted, cluster is found by its service,
+// so failed delete can be started again
 // @param cluster - CouchdbCluster struct with info about cluster we want delete (required: namespace, tag, username, labels)
 // @return error -  error if something goes wrong
 func (cluster *CouchdbCluster) DeleteCouchdbCluster() (error) {
-	// Delete service
-	err := cluster.DeleteClusterService()
-	if err != nil{
-		ErrorLog("kube_control: deleteCouchdb cluster: delete service")
+	// spawner type is saved in service, load it before service is deleted
+	err := cluster.LoadSpawnerType()
+	if err != nil {
+		ErrorLog("kube_control: deleteCouchdb cluster: load spawner type")
+		return err
 	}
+	// every component is deleted even if other component cannot be deleted, all failures are returned together
+	failures := []string{}
+	failed := func(step string, component string, err error) {
+		ErrorLog("kube_control: deleteCouchdb cluster: delete "+component)
+		ErrorLog(err)
+		cluster.Operation.StepFailed(step, errors.New(component+": "+err.Error()))
+		failures = append(failures, component+": "+err.Error())
+	}
+
+	cluster.Operation.StepRunning(STEP_SPAWNER_DELETED)
 	// delete spawner
-	if SPAWNER_TYPE == COMPONENT_DEPLOYMENT {
-		err = cluster.DeleteDeployment()
-	} else if SPAWNER_TYPE == COMPONENT_RC {
-		err = cluster.DeleteReplicationControllers()
-	} else if SPAWNER_TYPE == COMPONENT_PETSET {
-		// TODO petset deletion
+	spawner, err := cluster.Spawner()
+	if err == nil {
+		err = spawner.Delete(cluster)
 	}
 	// check for delete errors
 	if err != nil{
-		ErrorLog("kube_control: deleteCouchdb cluster: delete spawner")
+		failed(STEP_SPAWNER_DELETED, "spawner", err)
+	} else {
+		cluster.Operation.StepSucceeded(STEP_SPAWNER_DELETED)
 	}
-	// wait for spawner to be deleted, so it wont spawn another pods,
-	// there should be something better than hardcoded wait,
-	// some checks for kubernetes rs
-	time.Sleep(time.Millisecond*600)
+	// spawner waits until its replica sets or controllers are gone, so it wont spawn another pods
 
 	// delete all remaining pods
+	cluster.Operation.StepRunning(STEP_PODS_DELETED)
 	err = cluster.DeletePods()
+	if err == nil {
+		// pods still use credentials secret and claims until they are terminated
+		err = cluster.WaitForPodsDeleted(DELETE_TIMEOUT)
+	}
 	if err != nil {
-		ErrorLog("kube_control: delete deployment: delete pods")
-		return err
+		failed(STEP_PODS_DELETED, "pods", err)
+		return deleteError(failures)
 	}
-	// no error
+	cluster.Operation.StepSucceeded(STEP_PODS_DELETED)
+	// delete admin credentials
+	err = cluster.DeleteCredentialsSecret()
+	if err != nil {
+		ErrorLog("kube_control: deleteCouchdb cluster: delete credentials secret")
+		failures = append(failures, "credentials secret: "+err.Error())
+	}
+	// forget replicated databases, so new cluster with same tag starts with defaults
+	err = METADATA_STORE.DeleteReplDatabases(cluster.Username, cluster.Tag)
+	if err != nil {
+		ErrorLog("kube_control: deleteCouchdb cluster: delete metadata")
+		failures = append(failures, "metadata: "+err.Error())
+	}
+	if len(failures) > 0 {
+		// keep service, so cluster can be found and deleted again
+		return deleteError(failures)
+	}
+
+	// Delete pod disruption budget, ingress and service
+	cluster.Operation.StepRunning(STEP_SERVICE_DELETED)
+	if err = cluster.DeleteDisruptionBudget(); err != nil {
+		failed(STEP_SERVICE_DELETED, "pod disruption budget", err)
+	}
+	if err = cluster.DeleteClusterIngress(); err != nil {
+		failed(STEP_SERVICE_DELETED, "ingress", err)
+	}
+	if len(failures) > 0 {
+		return deleteError(failures)
+	}
+	if err = cluster.DeleteClusterService(); err != nil {
+		failed(STEP_SERVICE_DELETED, "service", err)
+		return deleteError(failures)
+	}
+	cluster.Operation.StepSucceeded(STEP_SERVICE_DELETED)
 	return nil
 }
 
+// combine failures of cluster delete to one error
+// @param failures []string - "component: error" of each failed component
+// @return error - nil if nothing failed
+func deleteError(failures []string) error {
+	if len(failures) == 0 {
+		return nil
+	}
+	return errors.New("cluster was not deleted completely, failed: "+strings.Join(failures, ", "))
+}
+
+// init cluster struct with labels for user and tag
+// @param username string
+// @param tag string - cluster tag
+// @param namespace string
+// @return *CouchdbCluster
+func NewCouchdbCluster(username string, tag string, namespace string) (*CouchdbCluster) {
+	// labels for cluster components
+	labels := make(map[string]string)
+	labels[LABEL_USER] = username
+	labels[LABEL_CLUSTER_TAG] = tag
+
+	return &CouchdbCluster{Tag: tag, Username: username, Namespace: namespace, Labels: labels}
+}
+
 // list all couchdb clusters for user
 // @param username - string
 // @return *[]CouchdbCLuster - array of all couchdb clusters
@@ -172,29 +383,39 @@ func ListCouchdbClusters(username string, namespace string) (*[]CouchdbCluster,
 	// result array
 	clusters :=  []CouchdbCluster{}
 
-	// get kube extensions api
-	c, err := KubeClient(KUBE_API)
-	if err != nil {
-		ErrorLog("kube control; listCouchdbclusters: get kube client error")
-		ErrorLog(err)
-		return nil, err
-	}
-	// list options
-	userLabels := make(map[string]string)
-	userLabels[LABEL_USER] = username
-	listOptions := api.ListOptions{LabelSelector: labels.SelectorFromSet(labels.Set(userLabels))}
+	// services of user, from cache or api
+	services, ok := KUBE_CACHE.UserServices(namespace, username)
+	if !ok {
+		// get kube extensions api
+		c, err := KubeClient(KUBE_API)
+		if err != nil {
+			ErrorLog("kube control; listCouchdbclusters: get kube client error")
+			ErrorLog(err)
+			return nil, err
+		}
+		// list options
+		userLabels := make(map[string]string)
+		userLabels[LABEL_USER] = username
+		listOptions := api.ListOptions{LabelSelector: labels.SelectorFromSet(labels.Set(userLabels))}
 
-	// get all deployments
-	serviceList, err := c.Services(namespace).List(listOptions)
-	if err != nil {
-		ErrorLog("kube control; listCouchdbclusters: get service list error")
-		ErrorLog(err)
-		return nil, err
+		// get all deployments
+		serviceList, err := c.Services(namespace).List(listOptions)
+		if err != nil {
+			ErrorLog("kube control; listCouchdbclusters: get service list error")
+			ErrorLog(err)
+			return nil, err
+		}
+		services = serviceList.Items
 	}
 	// iterate through all services
-	for _, service := range serviceList.Items {
-		// get tag from deployment name
-		tag := strings.TrimLeft(service.Name, CLUSTER_PREFIX)
+	for _, service := range services {
+		// skip services of single pods and headless services of pet sets
+		if service.Labels[LABEL_POD_SERVICE] != "" || service.Labels[LABEL_PETSET_SERVICE] != "" ||
+			!strings.HasPrefix(service.Name, CLUSTER_PREFIX) {
+			continue
+		}
+		// get tag from service name
+		tag := strings.TrimPrefix(service.Name, CLUSTER_PREFIX)
 		// inti labels for cluster
 		labels := make(map[string]string)
 		labels[LABEL_USER] = username
@@ -202,31 +423,16 @@ func ListCouchdbClusters(username string, namespace string) (*[]CouchdbCluster,
 
 		// init cluster struct
 		cluster := &CouchdbCluster{Tag: tag, Username: username, Namespace: namespace,
-					Endpoint: service.Spec.ClusterIP, Labels: labels}
+					Endpoint: ServiceEndpoint(&service), Labels: labels, SpawnerType: SpawnerTypeOf(&service),
+					Resources: ResourcesOf(&service), Storage: StorageOf(&service), Exposure: ExposureOf(&service),
+					Placement: PlacementOf(&service), ReadCache: true}
 		// get replica count
-		if SPAWNER_TYPE == COMPONENT_DEPLOYMENT {
-			// get deployment
-			deployment, err := cluster.GetDeployment()
-			if err != nil {
-				ErrorLog("kube control; listCouchdbclusters: get deployment error")
-				ErrorLog(err)
-			} else {
-				// replica number
-				cluster.Replicas = deployment.Spec.Replicas
-			}
-		} else if SPAWNER_TYPE == COMPONENT_RC {
-			// get all replica controllers
-			rcList, err := cluster.GetReplicationControllers()
-			if err != nil {
-				ErrorLog("kube control; listCouchdbclusters: get repl controllers error")
-				ErrorLog(err)
-			} else {
-				// each replication controller means one replica for couchdb cluster
-				cluster.Replicas = int32(len(*rcList))
-			}
-		} else if SPAWNER_TYPE == COMPONENT_PETSET {
-			// TODO
+		err := cluster.LoadReplicas()
+		if err != nil {
+			ErrorLog("kube control; listCouchdbclusters: load replicas error")
+			ErrorLog(err)
 		}
+		cluster.Reconcile = RECONCILER.Status(cluster)
 		// add cluster to array
 		clusters = append(clusters, *cluster)
 	}
@@ -234,23 +440,101 @@ func ListCouchdbClusters(username string, namespace string) (*[]CouchdbCluster,
 	return &clusters, nil
 }
 
-// list all couchdb clusters for user
-// @param username - string
-// @return *[]CouchdbCLuster - array of all couchdb clusters
+// load current replica count from cluster spawner and save it to cluster.Replicas
+// @param cluster *CouchdbCluster - required: tag, namespace, labels
+// @return error
+func (cluster *CouchdbCluster) LoadReplicas() (error) {
+	spawner, err := cluster.Spawner()
+	if err != nil {
+		return err
+	}
+	replicas, err := spawner.CurrentReplicas(cluster)
+	if err != nil {
+		ErrorLog("kube control: LoadReplicas: get replicas error")
+		return err
+	}
+	// save replicas number
+	cluster.Replicas = replicas
+	return nil
+}
+
+// scale couchdb cluster to cluster.Replicas and change resources to cluster.Resources
+// @param cluster *CouchdbCluster - cluster with new replica number, nil resources keep current resources
+// @return error
 func (cluster *CouchdbCluster) ScaleCouchdbCluster() (error) {
-	var err error
-
-	if SPAWNER_TYPE == COMPONENT_DEPLOYMENT {
-		deployment, _ := cluster.GetDeployment()
-		// its ok, scale cluster
-		err = cluster.ScaleDeployment(deployment)
-	} else if SPAWNER_TYPE == COMPONENT_RC {
-		//get rrc list
-		rcList, _ := cluster.GetReplicationControllers()
-		// scale replication controllers
-		err = cluster.ScaleRC(rcList)
-	}
-	return err
+	service, err := cluster.GetClusterService()
+	if err != nil {
+		ErrorLog("kube control: ScaleCouchdbCluster: get cluster service error")
+		return err
+	}
+	// claims of new pods have same size and class as existing claims
+	cluster.Storage = StorageOf(service)
+	// new pods are placed same way as existing pods
+	cluster.Placement = PlacementOf(service)
+
+	cluster.Operation.StepRunning(STEP_RESOURCES_UPDATED)
+	resourcesChanged := false
+	if cluster.Resources == nil {
+		// keep resources of running cluster, new pods are created with them
+		cluster.Resources = ResourcesOf(service)
+		cluster.Operation.StepSkipped(STEP_RESOURCES_UPDATED)
+	} else {
+		changed, err := cluster.UpdateResources()
+		if err != nil {
+			ErrorLog("kube control: ScaleCouchdbCluster: update resources error")
+			return err
+		}
+		resourcesChanged = changed
+		cluster.Operation.StepSucceeded(STEP_RESOURCES_UPDATED)
+	}
+
+	cluster.Operation.StepRunning(STEP_SPAWNER_SCALED)
+	spawner, err := cluster.Spawner()
+	if err != nil {
+		return err
+	}
+	currentReplicas, err := spawner.CurrentReplicas(cluster)
+	if err != nil {
+		ErrorLog("kube control: ScaleCouchdbCluster: get replicas error")
+		return err
+	}
+	if currentReplicas == cluster.Replicas {
+		cluster.Operation.StepSkipped(STEP_SPAWNER_SCALED)
+		if !resourcesChanged {
+			// nothing to do
+			cluster.Operation.StepSkipped(STEP_PODS_READY)
+			cluster.Operation.StepSkipped(STEP_REPLICATION_CONFIGURED)
+			return nil
+		}
+		// pods may be replaced with new resources, replication is reconfigured below
+	} else {
+		err = spawner.Scale(cluster)
+		if err != nil {
+			ErrorLog("kube control: ScaleCouchdbCluster: scale error")
+			return err
+		}
+		// keep budget in step with new replica count
+		_, err = cluster.SyncDisruptionBudget()
+		if err != nil {
+			ErrorLog("kube control: ScaleCouchdbCluster: sync pod disruption budget error")
+			return err
+		}
+		cluster.Operation.StepSucceeded(STEP_SPAWNER_SCALED)
+	}
+
+	// we need to reconfigure replication
+	databases, err := cluster.DatabasesToReplicate()
+	if err != nil {
+		ErrorLog("kube control: ScaleCouchdbCluster: load replicated databases error")
+		return err
+	}
+	err = cluster.SetupReplication(databases)
+	if err != nil {
+		ErrorLog("kube control: ScaleCouchdbCluster: reconfigure replication error")
+		return err
+	}
+	//everything OK
+	return nil
 }
 
 
@@ -265,11 +549,30 @@ func (cluster *CouchdbCluster) CreateClusterService() (*api.Service, error) {
 	// service ports
 	svcPorts := api.ServicePort{Port: COUCHDB_PORT}
 	// service specs
-	serviceSpec := api.ServiceSpec{Selector: cluster.Labels, Ports: []api.ServicePort{svcPorts}}
+	serviceSpec := api.ServiceSpec{Selector: cluster.Labels, Ports: []api.ServicePort{svcPorts}, Type: cluster.serviceType()}
 	// init service struct
 	service := api.Service{Spec: serviceSpec}
 	service.Name = CLUSTER_PREFIX + cluster.Tag
 	service.Labels = cluster.Labels
+	// remember spawner type, so cluster is managed by same spawner when SPAWNER_TYPE changes
+	service.Annotations = map[string]string{ANNOTATION_SPAWNER_TYPE: cluster.SpawnerType}
+	// remember resources, storage and placement, scaling creates new pods and claims with them
+	if cluster.Resources != nil {
+		service.Annotations[ANNOTATION_RESOURCES] = cluster.resourcesAnnotation()
+	}
+	if cluster.Storage != nil {
+		service.Annotations[ANNOTATION_STORAGE] = cluster.storageAnnotation()
+	}
+	if cluster.Placement != nil {
+		service.Annotations[ANNOTATION_PLACEMENT] = cluster.placementAnnotation()
+	}
+	// remember exposure, endpoint of cluster is computed from it
+	if cluster.Exposure != "" {
+		service.Annotations[ANNOTATION_EXPOSURE] = cluster.Exposure
+	}
+	if cluster.Exposure == EXPOSURE_INGRESS {
+		service.Annotations[ANNOTATION_INGRESS_HOST] = cluster.IngressHost()
+	}
 	// get a new kube client
 	c, err := KubeClient(KUBE_API)
 	// check for errors
@@ -289,6 +592,10 @@ func (cluster *CouchdbCluster) CreateClusterService() (*api.Service, error) {
 // @return *api.Service - found deployment, return nil if deployment was not found
 // @return error - any error that occurs during fetching deployment
 func (cluster *CouchdbCluster) GetClusterService() (*api.Service, error) {
+	// cached service, cluster missing in cache can be just created, so ask api
+	if svc, ok := cluster.cache().ClusterService(cluster); ok && svc != nil {
+		return svc, nil
+	}
 	// get kube api
 	c, err := KubeClient(KUBE_API)
 	// check for errors
@@ -318,7 +625,31 @@ func (cluster *CouchdbCluster) GetClusterService() (*api.Service, error) {
 		}
 	}
 	// nothing matches, return fail
-	return nil,  errors.New("service not found")
+	return nil,  ErrClusterNotFound
+}
+
+// check if any cluster with this tag exists in namespace
+// service name has to be unique in namespace, so tag can be used only once, even by different users
+// @param cluster *CouchdbCluster - required: tag, namespace
+// @return bool - true if cluster service already exists
+// @return error
+func (cluster *CouchdbCluster) ClusterExists() (bool, error) {
+	// get kube api
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("kube control: ClusterExists: Cannot connect to Kubernetes api ")
+		ErrorLog(err)
+		return false, err
+	}
+	_, err = c.Services(cluster.Namespace).Get(CLUSTER_PREFIX + cluster.Tag)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		ErrorLog("kube control: ClusterExists: get service error")
+		ErrorLog(err)
+		return false, err
+	}
+	return true, nil
 }
 // delete service for couchdb cluster
 // @param cluster *CouchdbCluster - cluster that will be deleted
@@ -345,6 +676,10 @@ func (cluster *CouchdbCluster) DeleteClusterService() (error) {
 // @return []*api.Pod - all pods that belong to this couchdb cluster
 // @return error - any error that occurs during fetching deployment
 func (cluster *CouchdbCluster) GetPods() (*[]api.Pod, error) {
+	// cached pods
+	if pods, ok := cluster.cache().ClusterPods(cluster); ok {
+		return &pods, nil
+	}
 	// get kube api
 	c, err := KubeClient(KUBE_API)
 	// check for errors
@@ -416,19 +751,26 @@ func (cluster *CouchdbCluster) DeletePods() (error) {
 // init podTemplate for kubernetes
 // @param cluster *CouchdbCluster - cluster for which podTemplate will be
 // @param volumes bool - if true podTemplate will have also configured persistent volumes
-// @param pvClaimName string - required only when volumes is true, persistent volume claim that will be bound to this pod
+// @param pvClaimName string - persistent volume claim that will be bound to this pod, empty for pet set (only volume mount is added)
 // @return *api.PodTemplateSpec - initialized podTemplateSpec
 func (cluster *CouchdbCluster) CouchdbPodTemplate(volumes bool, pvcClaimName string) (*api.PodTemplateSpec) {
 	// container ports init
 	contPort := api.ContainerPort{ContainerPort: COUCHDB_PORT}
 
-	// container env init
-	contEnv_dbName := api.EnvVar{Name: "COUCHDB_USER", Value: cluster.Username}
-	contEnv_dbPass := api.EnvVar{Name: "COUCHDB_PASSWORD", Value: cluster.Password}
+	// container env init, admin credentials are read from cluster secret
+	contEnv_dbName := cluster.secretEnvVar("COUCHDB_USER", SECRET_KEY_USERNAME)
+	contEnv_dbPass := cluster.secretEnvVar("COUCHDB_PASSWORD", SECRET_KEY_PASSWORD)
 
 	// container specs
 	container := api.Container{Name: CLUSTER_PREFIX + "-"+ cluster.Tag, Image: DOCKER_IMAGE,
 						Ports: []api.ContainerPort{contPort}, Env: []api.EnvVar{contEnv_dbName, contEnv_dbPass}}
+	// couchdb http probes, service sends traffic only to ready pods
+	container.ReadinessProbe = READINESS_PROBE.Probe()
+	container.LivenessProbe = LIVENESS_PROBE.Probe()
+	// cpu and memory requests and limits
+	if cluster.Resources != nil {
+		container.Resources = *cluster.Resources
+	}
 
 	//VOLUMES in container
 	if volumes {
@@ -440,8 +782,8 @@ func (cluster *CouchdbCluster) CouchdbPodTemplate(volumes bool, pvcClaimName str
 	// pod specifications
 	podSpec := api.PodSpec{Containers:[]api.Container{container}}
 
-	// assign PVC for container
-	if volumes {
+	// assign PVC for container, pet set creates volume from its claim template
+	if volumes && pvcClaimName != "" {
 		// persistent volumes, claims
 		pvClaim := api.PersistentVolumeClaimVolumeSource{ClaimName: pvcClaimName}
 		volume := api.Volume{Name:CLUSTER_PREFIX + cluster.Tag}
@@ -453,6 +795,8 @@ func (cluster *CouchdbCluster) CouchdbPodTemplate(volumes bool, pvcClaimName str
 	// pod template spec
 	podTemplateSpec := api.PodTemplateSpec{Spec: podSpec}
 	podTemplateSpec.Labels = cluster.Labels
+	// replicas on different nodes (and zones)
+	cluster.applyPlacement(&podTemplateSpec)
 
 	return &podTemplateSpec
 }
\ No newline at end of file
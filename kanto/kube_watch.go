@@ -0,0 +1,303 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for waiting on kubernetes resources
+// instead of polling and fixed sleeps, resources are listed once and then watched for changes
+// until condition is met or deadline expires
+package kanto
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/meta"
+	"k8s.io/kubernetes/pkg/api/resource"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+// max time to wait for pods to become ready, can be overwritten by os ENV "POD_READY_TIMEOUT"
+var POD_READY_TIMEOUT = 5 * time.Minute
+
+// max time to wait for deleted resources to disappear, can be overwritten by os ENV "DELETE_TIMEOUT"
+var DELETE_TIMEOUT = 2 * time.Minute
+
+// error returned when deadline expires before resources reach expected state
+type WaitTimeoutError struct {
+	// what was waited for, ie. "pods ready"
+	What string
+	// resources that did not reach expected state
+	Pending []string
+}
+
+func (e *WaitTimeoutError) Error() string {
+	return "timeout waiting for " + e.What + ", still pending: " + strings.Join(e.Pending, ", ")
+}
+
+// list resources and watch them until pending returns empty list
+// watch is restarted from new list when it is closed by api server
+// @param what string - description for logs and errors
+// @param timeout time.Duration - deadline for whole wait
+// @param list func() ([]runtime.Object, string, error) - list resources, returns objects and resource version of list
+// @param watchFrom func(string) (watch.Interface, error) - watch resources from resource version
+// @param pending func(map[string]runtime.Object) []string - names of resources that are not in expected state
+// @return error - *WaitTimeoutError when deadline expires
+func waitFor(what string, timeout time.Duration, list func() ([]runtime.Object, string, error),
+	watchFrom func(string) (watch.Interface, error), pending func(map[string]runtime.Object) []string) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		// current state
+		items, resourceVersion, err := list()
+		if err != nil {
+			ErrorLog("kube_watch: waitFor " + what + ": list error")
+			return err
+		}
+		objects := make(map[string]runtime.Object)
+		for _, obj := range items {
+			if name, err := objectName(obj); err == nil {
+				objects[name] = obj
+			}
+		}
+		waiting := pending(objects)
+		if len(waiting) == 0 {
+			return nil
+		}
+
+		// watch changes since list
+		w, err := watchFrom(resourceVersion)
+		if err != nil {
+			ErrorLog("kube_watch: waitFor " + what + ": watch error")
+			return err
+		}
+		closed := false
+		for !closed {
+			select {
+			case event, ok := <-w.ResultChan():
+				if !ok || event.Type == watch.Error {
+					// watch expired, list again
+					closed = true
+					continue
+				}
+				name, err := objectName(event.Object)
+				if err != nil {
+					continue
+				}
+				if event.Type == watch.Deleted {
+					delete(objects, name)
+				} else {
+					objects[name] = event.Object
+				}
+				waiting = pending(objects)
+				if len(waiting) == 0 {
+					w.Stop()
+					return nil
+				}
+			case <-timer.C:
+				w.Stop()
+				ErrorLog("kube_watch: timeout waiting for " + what)
+				return &WaitTimeoutError{What: what, Pending: waiting}
+			}
+		}
+		w.Stop()
+	}
+}
+
+// name of kubernetes object
+func objectName(obj runtime.Object) (string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return "", err
+	}
+	return accessor.GetName(), nil
+}
+
+// pending names for deletion, every existing resource is pending
+func allPending(objects map[string]runtime.Object) []string {
+	names := []string{}
+	for name := range objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// list options with cluster label selector
+func (cluster *CouchdbCluster) watchOptions(resourceVersion string) api.ListOptions {
+	return api.ListOptions{LabelSelector: labels.SelectorFromSet(labels.Set(cluster.Labels)), ResourceVersion: resourceVersion}
+}
+
+// list and watch functions for pods of cluster
+func (cluster *CouchdbCluster) watchPods() (func() ([]runtime.Object, string, error), func(string) (watch.Interface, error), error) {
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("kube_watch: watchPods: Cannot connect to Kubernetes api ")
+		ErrorLog(err)
+		return nil, nil, err
+	}
+	list := func() ([]runtime.Object, string, error) {
+		podList, err := c.Pods(cluster.Namespace).List(cluster.watchOptions(""))
+		if err != nil {
+			return nil, "", err
+		}
+		items := []runtime.Object{}
+		for i := range podList.Items {
+			items = append(items, &podList.Items[i])
+		}
+		return items, podList.ResourceVersion, nil
+	}
+	watchFrom := func(resourceVersion string) (watch.Interface, error) {
+		return c.Pods(cluster.Namespace).Watch(cluster.watchOptions(resourceVersion))
+	}
+	return list, watchFrom, nil
+}
+
+// wait until cluster has cluster.Replicas pods and all of them have Ready condition
+// terminating pods are ignored
+// @param cluster *CouchdbCluster - required: labels, namespace, replicas
+// @param timeout time.Duration
+// @return error - *WaitTimeoutError with pods that are not ready
+func (cluster *CouchdbCluster) WaitForPodsReady(timeout time.Duration) error {
+	list, watchFrom, err := cluster.watchPods()
+	if err != nil {
+		return err
+	}
+	replicas := int(cluster.Replicas)
+	pending := func(objects map[string]runtime.Object) []string {
+		waiting := []string{}
+		count := 0
+		for name, obj := range objects {
+			pod, ok := obj.(*api.Pod)
+			if !ok || pod.DeletionTimestamp != nil {
+				continue
+			}
+			count++
+			// pod is ready when couchdb answers readiness probe, phase is only shown
+			if !api.IsPodReady(pod) {
+				waiting = append(waiting, name+" ("+string(pod.Status.Phase)+", not ready)")
+			}
+		}
+		sort.Strings(waiting)
+		if count < replicas {
+			waiting = append(waiting, strconv.Itoa(replicas-count)+" pods not created yet")
+		} else if count > replicas {
+			waiting = append(waiting, strconv.Itoa(count-replicas)+" pods not terminated yet")
+		}
+		return waiting
+	}
+	return waitFor("pods ready", timeout, list, watchFrom, pending)
+}
+
+// wait until all pods of cluster are deleted
+// @param cluster *CouchdbCluster - required: labels, namespace
+// @param timeout time.Duration
+// @return error - *WaitTimeoutError with pods that still exist
+func (cluster *CouchdbCluster) WaitForPodsDeleted(timeout time.Duration) error {
+	list, watchFrom, err := cluster.watchPods()
+	if err != nil {
+		return err
+	}
+	return waitFor("pods deleted", timeout, list, watchFrom, allPending)
+}
+
+// wait until all replica sets of cluster are deleted
+// @param cluster *CouchdbCluster - required: labels, namespace
+// @param timeout time.Duration
+// @return error - *WaitTimeoutError with replica sets that still exist
+func (cluster *CouchdbCluster) WaitForReplicaSetsDeleted(timeout time.Duration) error {
+	c, err := KubeClientExtensions(KUBE_API)
+	if err != nil {
+		ErrorLog("kube_watch: WaitForReplicaSetsDeleted: Cannot connect to Kubernetes api ")
+		ErrorLog(err)
+		return err
+	}
+	list := func() ([]runtime.Object, string, error) {
+		rsList, err := c.ReplicaSets(cluster.Namespace).List(cluster.watchOptions(""))
+		if err != nil {
+			return nil, "", err
+		}
+		items := []runtime.Object{}
+		for i := range rsList.Items {
+			items = append(items, &rsList.Items[i])
+		}
+		return items, rsList.ResourceVersion, nil
+	}
+	watchFrom := func(resourceVersion string) (watch.Interface, error) {
+		return c.ReplicaSets(cluster.Namespace).Watch(cluster.watchOptions(resourceVersion))
+	}
+	return waitFor("replica sets deleted", timeout, list, watchFrom, allPending)
+}
+
+// wait until all replication controllers of cluster are deleted
+// @param cluster *CouchdbCluster - required: labels, namespace
+// @param timeout time.Duration
+// @return error - *WaitTimeoutError with replication controllers that still exist
+func (cluster *CouchdbCluster) WaitForReplicationControllersDeleted(timeout time.Duration) error {
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("kube_watch: WaitForReplicationControllersDeleted: Cannot connect to Kubernetes api ")
+		ErrorLog(err)
+		return err
+	}
+	list := func() ([]runtime.Object, string, error) {
+		rcList, err := c.ReplicationControllers(cluster.Namespace).List(cluster.watchOptions(""))
+		if err != nil {
+			return nil, "", err
+		}
+		items := []runtime.Object{}
+		for i := range rcList.Items {
+			items = append(items, &rcList.Items[i])
+		}
+		return items, rcList.ResourceVersion, nil
+	}
+	watchFrom := func(resourceVersion string) (watch.Interface, error) {
+		return c.ReplicationControllers(cluster.Namespace).Watch(cluster.watchOptions(resourceVersion))
+	}
+	return waitFor("replication controllers deleted", timeout, list, watchFrom, allPending)
+}
+
+// wait until claim is bound and its capacity is at least size, used after claim size was increased
+// @param cluster *CouchdbCluster - required: labels, namespace
+// @param name string - claim name
+// @param size resource.Quantity - requested size
+// @param timeout time.Duration
+// @return error - *WaitTimeoutError with claim and its current capacity
+func (cluster *CouchdbCluster) WaitForClaimCapacity(name string, size resource.Quantity, timeout time.Duration) error {
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("kube_watch: WaitForClaimCapacity: Cannot connect to Kubernetes api ")
+		ErrorLog(err)
+		return err
+	}
+	list := func() ([]runtime.Object, string, error) {
+		pvcList, err := c.PersistentVolumeClaims(cluster.Namespace).List(cluster.watchOptions(""))
+		if err != nil {
+			return nil, "", err
+		}
+		items := []runtime.Object{}
+		for i := range pvcList.Items {
+			items = append(items, &pvcList.Items[i])
+		}
+		return items, pvcList.ResourceVersion, nil
+	}
+	watchFrom := func(resourceVersion string) (watch.Interface, error) {
+		return c.PersistentVolumeClaims(cluster.Namespace).Watch(cluster.watchOptions(resourceVersion))
+	}
+	pending := func(objects map[string]runtime.Object) []string {
+		pvc, ok := objects[name].(*api.PersistentVolumeClaim)
+		if !ok {
+			return []string{name + " (not found)"}
+		}
+		capacity := pvc.Status.Capacity[api.ResourceStorage]
+		if pvc.Status.Phase != api.ClaimBound || capacity.Cmp(size) < 0 {
+			return []string{name + " (" + string(pvc.Status.Phase) + ", capacity " + capacity.String() + ")"}
+		}
+		return nil
+	}
+	return waitFor("volume claim expanded", timeout, list, watchFrom, pending)
+}
@@ -0,0 +1,280 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for persistent metadata storage
+// kanto itself is stateless, everything that cannot be read back from kubernetes is saved here
+package kanto
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/patrickjuchli/couch"
+)
+
+const (
+	METADATA_STORE_FILE    = "file"
+	METADATA_STORE_COUCHDB = "couchdb"
+)
+
+// default path for file metadata store, can be overwritten by os ENV "METADATA_FILE"
+var METADATA_FILE string = "./kanto_metadata.json"
+
+// metadata store used by kanto, configured in main
+var METADATA_STORE MetadataStore
+
+// interface for persistent metadata storage
+// metadata are saved per cluster, cluster is identified by username and cluster tag
+type MetadataStore interface {
+	// load list of replicated databases for cluster
+	// @param username string
+	// @param tag string - cluster tag
+	// @return []string - saved databases, nil if nothing was saved yet
+	// @return error
+	ReplDatabases(username string, tag string) ([]string, error)
+	// save list of replicated databases for cluster, overwrites old list
+	// @param username string
+	// @param tag string - cluster tag
+	// @param dbs []string - databases to save
+	// @return error
+	SaveReplDatabases(username string, tag string, dbs []string) error
+	// delete all metadata for cluster
+	// @param username string
+	// @param tag string - cluster tag
+	// @return error
+	DeleteReplDatabases(username string, tag string) error
+}
+
+// metadata store saved in local json file
+// whole file is loaded into memory and rewritten on every change
+type FileMetadataStore struct {
+	// path to json file
+	Path string
+
+	mutex sync.Mutex
+	// username -> cluster tag -> databases
+	data map[string]map[string][]string
+}
+
+// open file metadata store, create empty store if file does not exist
+// @param path string - path to json file
+// @return *FileMetadataStore
+// @return error
+func NewFileMetadataStore(path string) (*FileMetadataStore, error) {
+	store := &FileMetadataStore{Path: path, data: make(map[string]map[string][]string)}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		// new store
+		DebugLog("metadata_store: file store does not exist yet, creating new: " + path)
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+	// empty file is valid empty store
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, &store.data); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+func (s *FileMetadataStore) ReplDatabases(username string, tag string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.data[username][tag], nil
+}
+
+func (s *FileMetadataStore) SaveReplDatabases(username string, tag string, dbs []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.data[username] == nil {
+		s.data[username] = make(map[string][]string)
+	}
+	s.data[username][tag] = dbs
+	return s.write()
+}
+
+func (s *FileMetadataStore) DeleteReplDatabases(username string, tag string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.data[username][tag]; !ok {
+		// nothing to delete
+		return nil
+	}
+	delete(s.data[username], tag)
+	if len(s.data[username]) == 0 {
+		delete(s.data, username)
+	}
+	return s.write()
+}
+
+// write whole store to file
+// data are written to temporary file first and then renamed, so store is never half written
+// caller has to hold mutex
+// @return error
+func (s *FileMetadataStore) write() error {
+	content, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(s.Path), ".kanto_metadata")
+	if err != nil {
+		return err
+	}
+	if _, err := tmpFile.Write(content); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return os.Rename(tmpFile.Name(), s.Path)
+}
+
+// couchdb document with metadata for one cluster
+type metadataDocument struct {
+	Id         string   `json:"_id"`
+	Rev        string   `json:"_rev,omitempty"`
+	Username   string   `json:"username"`
+	ClusterTag string   `json:"cluster_tag"`
+	Databases  []string `json:"databases"`
+}
+
+// metadata store saved in couchdb database
+// each cluster has one document with id "username:cluster_tag"
+type CouchdbMetadataStore struct {
+	server   *couch.Server
+	database string
+}
+
+// connect to couchdb metadata store, database is created if it does not exist
+// @param serverUrl string - couchdb server url
+// @param username string - couchdb user
+// @param password string - couchdb password
+// @param database string - database for metadata
+// @return *CouchdbMetadataStore
+// @return error
+func NewCouchdbMetadataStore(serverUrl string, username string, password string, database string) (*CouchdbMetadataStore, error) {
+	server := couch.NewServer(serverUrl, couch.NewCredentials(username, password))
+	// check connection
+	if err := CheckServer(server, MAX_RETRIES, RETRY_WAIT_TIME); err != nil {
+		return nil, err
+	}
+	// create database, fails silently if it already exists
+	server.Database(database).Create()
+
+	return &CouchdbMetadataStore{server: server, database: database}, nil
+}
+
+// url of document for cluster
+// username cannot contain ":" (see user store file format), so document id is unique
+func (s *CouchdbMetadataStore) documentURL(username string, tag string) string {
+	return s.server.Database(s.database).URL() + "/" + url.QueryEscape(username+":"+tag)
+}
+
+// get document for cluster
+// @return *metadataDocument - nil if document does not exist
+// @return error
+func (s *CouchdbMetadataStore) document(username string, tag string) (*metadataDocument, error) {
+	doc := metadataDocument{}
+	resp, err := couch.Do(s.documentURL(username, tag), METHOD_GET, s.server.Cred(), nil, &doc)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		// nothing saved yet
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (s *CouchdbMetadataStore) ReplDatabases(username string, tag string) ([]string, error) {
+	doc, err := s.document(username, tag)
+	if err != nil || doc == nil {
+		return nil, err
+	}
+	return doc.Databases, nil
+}
+
+func (s *CouchdbMetadataStore) SaveReplDatabases(username string, tag string, dbs []string) error {
+	// get current revision
+	doc, err := s.document(username, tag)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		doc = &metadataDocument{Id: username + ":" + tag, Username: username, ClusterTag: tag}
+	}
+	doc.Databases = dbs
+	// save document
+	_, err = couch.Do(s.documentURL(username, tag), METHOD_PUT, s.server.Cred(), doc, nil)
+	return err
+}
+
+func (s *CouchdbMetadataStore) DeleteReplDatabases(username string, tag string) error {
+	// get current revision
+	doc, err := s.document(username, tag)
+	if err != nil || doc == nil {
+		return err
+	}
+	_, err = couch.Do(s.documentURL(username, tag)+"?rev="+doc.Rev, METHOD_DELETE, s.server.Cred(), nil, nil)
+	return err
+}
+
+// load databases that should be replicated in cluster from metadata store
+// if nothing was saved for cluster yet, default databases are returned
+// @param cluster *CouchdbCluster - required: username, tag
+// @return []string - databases to replicate
+// @return error - metadata store error
+func (cluster *CouchdbCluster) DatabasesToReplicate() ([]string, error) {
+	cluster_dbs, err := METADATA_STORE.ReplDatabases(cluster.Username, cluster.Tag)
+	if err != nil {
+		ErrorLog("metadata_store: DatabasesToReplicate: metadata store error")
+		ErrorLog(err)
+		return nil, err
+	}
+	if len(cluster_dbs) > 0 {
+		return cluster_dbs, nil
+	} else {
+		return DEFAULT_REPL_DATABASES, nil
+	}
+}
+
+// add databases to replication set of cluster and save it to metadata store
+// every database is saved only once
+// @param cluster *CouchdbCluster - required: username, tag
+// @param dbs []string - new databases
+// @return error - metadata store error
+func (cluster *CouchdbCluster) SaveReplDatabases(dbs []string) error {
+	cluster_dbs, err := cluster.DatabasesToReplicate()
+	if err != nil {
+		return err
+	}
+	// set union, keeps order in which databases were added
+	new_dbs := []string{}
+	seen := make(map[string]bool)
+	for _, db := range append(append([]string{}, cluster_dbs...), dbs...) {
+		if db == "" || seen[db] {
+			continue
+		}
+		seen[db] = true
+		new_dbs = append(new_dbs, db)
+	}
+	err = METADATA_STORE.SaveReplDatabases(cluster.Username, cluster.Tag, new_dbs)
+	if err != nil {
+		ErrorLog("metadata_store: SaveReplDatabases: metadata store error")
+		ErrorLog(err)
+	}
+	return err
+}
@@ -0,0 +1,413 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for asynchronous operations
+// mutating requests return operation id immediately and the work itself runs in background,
+// client can poll operation status and progress of each step
+package kanto
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// operation and step states
+	OPERATION_PENDING   = "pending"
+	OPERATION_RUNNING   = "running"
+	OPERATION_SUCCEEDED = "succeeded"
+	OPERATION_FAILED    = "failed"
+	OPERATION_SKIPPED   = "skipped"
+
+	// operation types
+	OPERATION_CREATE    = "create"
+	OPERATION_DELETE    = "delete"
+	OPERATION_SCALE     = "scale"
+	OPERATION_REPLICATE = "replicate"
+	OPERATION_ROTATE    = "rotate credentials"
+	OPERATION_RESIZE    = "resize storage"
+
+	// operation steps
+	STEP_NAMESPACE_READY        = "namespace ready"
+	STEP_CREDENTIALS_CREATED    = "credentials created"
+	STEP_SPAWNER_CREATED        = "spawner created"
+	STEP_SPAWNER_SCALED         = "spawner scaled"
+	STEP_SPAWNER_DELETED        = "spawner deleted"
+	STEP_SERVICE_CREATED        = "service created"
+	STEP_SERVICE_DELETED        = "service deleted"
+	STEP_PODS_READY             = "pods ready"
+	STEP_PODS_DELETED           = "pods deleted"
+	STEP_REPLICATION_CONFIGURED = "replication configured"
+	STEP_PASSWORD_CHANGED       = "password changed"
+	STEP_CREDENTIALS_UPDATED    = "credentials updated"
+	STEP_RESOURCES_UPDATED      = "resources updated"
+	STEP_VOLUMES_RESIZED        = "volumes resized"
+
+	// length of generated operation id
+	OPERATION_ID_LENGTH = 20
+	// finished operations are kept this long
+	OPERATION_TTL = time.Hour
+)
+
+// steps of each operation type, in order in which they are executed
+var OPERATION_STEPS = map[string][]string{
+	OPERATION_CREATE:    {STEP_NAMESPACE_READY, STEP_CREDENTIALS_CREATED, STEP_SPAWNER_CREATED, STEP_SERVICE_CREATED, STEP_PODS_READY, STEP_REPLICATION_CONFIGURED},
+	OPERATION_SCALE:     {STEP_RESOURCES_UPDATED, STEP_SPAWNER_SCALED, STEP_PODS_READY, STEP_REPLICATION_CONFIGURED},
+	OPERATION_DELETE:    {STEP_SPAWNER_DELETED, STEP_PODS_DELETED, STEP_SERVICE_DELETED},
+	OPERATION_REPLICATE: {STEP_PODS_READY, STEP_REPLICATION_CONFIGURED},
+	OPERATION_ROTATE:    {STEP_PODS_READY, STEP_PASSWORD_CHANGED, STEP_CREDENTIALS_UPDATED},
+	OPERATION_RESIZE:    {STEP_VOLUMES_RESIZED, STEP_PODS_READY, STEP_REPLICATION_CONFIGURED},
+}
+
+// error returned when cluster already has running operation
+var ErrOperationInProgress = errors.New("another operation is in progress for this cluster")
+
+// registry of all operations, operations are kept only in memory
+var OPERATIONS = &OperationRegistry{operations: make(map[string]*Operation), reconciling: make(map[string]bool)}
+
+// one step of operation
+type OperationStep struct {
+	Name     string     `json:"name"`
+	Status   string     `json:"status"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// errors of failed step, step can fail for more components
+	Error string `json:"error_detail,omitempty"`
+}
+
+// asynchronous operation on couchdb cluster
+type Operation struct {
+	Id         string           `json:"id"`
+	Type       string           `json:"type"`
+	ClusterTag string           `json:"cluster_tag"`
+	Status     string           `json:"status"`
+	Steps      []*OperationStep `json:"steps"`
+	Error      string           `json:"error_detail,omitempty"`
+	// result of rollback, set when failed create operation was rolled back
+	Rollback *RollbackError `json:"rollback,omitempty"`
+	// cluster info, updated when operation finishes
+	Result   *json.RawMessage `json:"result,omitempty"`
+	Created  time.Time        `json:"created"`
+	Finished *time.Time       `json:"finished,omitempty"`
+
+	// owner of operation, only owner can see it
+	Username string `json:"-"`
+
+	mutex sync.Mutex
+}
+
+// type without methods, used for json marshaling of operation
+type operationJSON Operation
+
+// marshal operation to json, safe to call while operation is running
+func (op *Operation) MarshalJSON() ([]byte, error) {
+	op.mutex.Lock()
+	defer op.mutex.Unlock()
+	return json.Marshal((*operationJSON)(op))
+}
+
+// find step by name, caller has to hold mutex
+func (op *Operation) step(name string) *OperationStep {
+	for _, step := range op.Steps {
+		if step.Name == name {
+			return step
+		}
+	}
+	return nil
+}
+
+// mark step as running
+// safe to call on nil operation (cluster operations called without async operation)
+// @param name string - step name
+func (op *Operation) StepRunning(name string) {
+	op.setStepStatus(name, OPERATION_RUNNING)
+}
+
+// mark step as successfully finished
+// @param name string - step name
+func (op *Operation) StepSucceeded(name string) {
+	op.setStepStatus(name, OPERATION_SUCCEEDED)
+}
+
+// mark step as skipped, ie. replication for cluster with one replica
+// @param name string - step name
+func (op *Operation) StepSkipped(name string) {
+	op.setStepStatus(name, OPERATION_SKIPPED)
+}
+
+// mark step as failed and add error to step errors
+// operation can continue with other steps, ie. delete continues with other components
+// @param name string - step name
+// @param err error - failure of step
+func (op *Operation) StepFailed(name string, err error) {
+	op.setStepStatus(name, OPERATION_FAILED)
+	if op == nil {
+		return
+	}
+	op.mutex.Lock()
+	defer op.mutex.Unlock()
+	if step := op.step(name); step != nil {
+		if step.Error != "" {
+			step.Error += "; "
+		}
+		step.Error += err.Error()
+	}
+}
+
+// change step status and timestamps
+func (op *Operation) setStepStatus(name string, status string) {
+	if op == nil {
+		return
+	}
+	op.mutex.Lock()
+	defer op.mutex.Unlock()
+
+	step := op.step(name)
+	if step == nil {
+		DebugLog("operations: unknown step " + name + " for operation type " + op.Type)
+		return
+	}
+	now := time.Now()
+	if status == OPERATION_RUNNING {
+		step.Started = &now
+	} else {
+		if step.Started == nil {
+			step.Started = &now
+		}
+		step.Finished = &now
+	}
+	step.Status = status
+}
+
+// save cluster info as operation result
+// admin password is never saved, operation can be read repeatedly
+// @param cluster *CouchdbCluster
+func (op *Operation) setResult(cluster *CouchdbCluster) {
+	result := *cluster
+	result.Password = ""
+	cluster_info, _ := json.Marshal(result)
+	op.mutex.Lock()
+	op.Result = (*json.RawMessage)(&cluster_info)
+	op.mutex.Unlock()
+}
+
+// mark operation as finished, unfinished steps are marked as failed
+// @param err error - operation error, nil if operation succeeded
+func (op *Operation) finish(err error) {
+	op.mutex.Lock()
+	defer op.mutex.Unlock()
+
+	now := time.Now()
+	op.Finished = &now
+	if err == nil {
+		op.Status = OPERATION_SUCCEEDED
+		return
+	}
+	op.Status = OPERATION_FAILED
+	op.Error = err.Error()
+	if rollback, ok := err.(*RollbackError); ok {
+		op.Rollback = rollback
+	}
+	for _, step := range op.Steps {
+		if step.Status == OPERATION_RUNNING {
+			step.Status = OPERATION_FAILED
+			step.Finished = &now
+		}
+	}
+}
+
+// marshal operation to json with admin password in cluster result
+// used only for create response, this is the only place where password is returned with cluster
+// @param password string - generated admin password
+// @return []byte - operation json
+// @return error
+func (op *Operation) MarshalWithPassword(password string) ([]byte, error) {
+	op_info, err := json.Marshal(op)
+	if err != nil {
+		return nil, err
+	}
+	// replace result with cluster info including password
+	op_map := make(map[string]*json.RawMessage)
+	if err := json.Unmarshal(op_info, &op_map); err != nil {
+		return nil, err
+	}
+	cluster := CouchdbCluster{}
+	if op_map["result"] != nil {
+		if err := json.Unmarshal(*op_map["result"], &cluster); err != nil {
+			return nil, err
+		}
+	}
+	cluster.Password = password
+	cluster_info, err := json.Marshal(cluster)
+	if err != nil {
+		return nil, err
+	}
+	op_map["result"] = (*json.RawMessage)(&cluster_info)
+	return json.Marshal(op_map)
+}
+
+// check if operation is finished
+func (op *Operation) IsFinished() bool {
+	op.mutex.Lock()
+	defer op.mutex.Unlock()
+	return op.Finished != nil
+}
+
+// registry of operations
+// operations and replication reconciler exclude each other, reconciler does not start while cluster has running operation
+// and operation waits for running reconcile of its cluster before it runs
+type OperationRegistry struct {
+	mutex      sync.Mutex
+	operations map[string]*Operation
+	// clusters with running reconcile, key is username/tag
+	reconciling map[string]bool
+	// signaled when reconcile finishes, uses mutex
+	reconcileDone *sync.Cond
+}
+
+// key of cluster in registry, operations are identified by owner and tag
+func operationKey(username string, tag string) string {
+	return username + "/" + tag
+}
+
+// create operation for cluster and run it in background
+// cluster can have only one running operation
+// @param opType string - operation type, one of OPERATION_CREATE, OPERATION_DELETE, ...
+// @param cluster *CouchdbCluster - cluster for operation, cluster.Operation is set to new operation
+// @param run func() error - operation itself
+// @return *Operation - started operation
+// @return error - ErrOperationInProgress if cluster has another running operation
+func (registry *OperationRegistry) Start(opType string, cluster *CouchdbCluster, run func() error) (*Operation, error) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	// remove old operations
+	registry.prune()
+	// only one operation per cluster
+	if registry.running(cluster.Username, cluster.Tag) {
+		return nil, ErrOperationInProgress
+	}
+
+	// init operation
+	op := &Operation{Id: RandStringName(OPERATION_ID_LENGTH), Type: opType, ClusterTag: cluster.Tag,
+		Status: OPERATION_PENDING, Created: time.Now(), Username: cluster.Username}
+	for _, name := range OPERATION_STEPS[opType] {
+		op.Steps = append(op.Steps, &OperationStep{Name: name, Status: OPERATION_PENDING})
+	}
+	op.setResult(cluster)
+	cluster.Operation = op
+	registry.operations[op.Id] = op
+
+	// run in background
+	go func() {
+		// reconcile started before operation can still write replication, wait for it
+		registry.waitForReconcile(operationKey(cluster.Username, cluster.Tag))
+
+		op.mutex.Lock()
+		op.Status = OPERATION_RUNNING
+		op.mutex.Unlock()
+
+		err := run()
+		if err != nil {
+			ErrorLog("operations: operation " + op.Id + " (" + op.Type + ") failed")
+			ErrorLog(err)
+		} else {
+			InfoLog("operations: operation " + op.Id + " (" + op.Type + ") finished for cluster_tag: " + op.ClusterTag)
+		}
+		op.setResult(cluster)
+		op.finish(err)
+	}()
+
+	return op, nil
+}
+
+// check if cluster has running operation
+// @param username string - cluster owner
+// @param tag string - cluster tag
+// @return bool
+func (registry *OperationRegistry) Running(username string, tag string) bool {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	return registry.running(username, tag)
+}
+
+// check if cluster has running operation, caller has to hold mutex
+func (registry *OperationRegistry) running(username string, tag string) bool {
+	for _, op := range registry.operations {
+		if op.Username == username && op.ClusterTag == tag && !op.IsFinished() {
+			return true
+		}
+	}
+	return false
+}
+
+// mark reconcile of cluster as running, operations started until EndReconcile wait for it
+// @param username string - cluster owner
+// @param tag string - cluster tag
+// @return bool - false if cluster has running operation, reconcile has to be skipped
+func (registry *OperationRegistry) BeginReconcile(username string, tag string) bool {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if registry.running(username, tag) {
+		return false
+	}
+	registry.reconciling[operationKey(username, tag)] = true
+	return true
+}
+
+// mark reconcile of cluster as finished and wake up waiting operations
+// @param username string - cluster owner
+// @param tag string - cluster tag
+func (registry *OperationRegistry) EndReconcile(username string, tag string) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	delete(registry.reconciling, operationKey(username, tag))
+	registry.cond().Broadcast()
+}
+
+// wait until reconcile of cluster finishes
+// @param key string - operationKey of cluster
+func (registry *OperationRegistry) waitForReconcile(key string) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	for registry.reconciling[key] {
+		registry.cond().Wait()
+	}
+}
+
+// condition signaled by EndReconcile, caller has to hold mutex
+func (registry *OperationRegistry) cond() *sync.Cond {
+	if registry.reconcileDone == nil {
+		registry.reconcileDone = sync.NewCond(&registry.mutex)
+	}
+	return registry.reconcileDone
+}
+
+// get operation for user
+// @param id string - operation id
+// @param username string - operation owner
+// @return *Operation - nil if operation does not exist or belongs to another user
+func (registry *OperationRegistry) Get(id string, username string) *Operation {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	op, ok := registry.operations[id]
+	if !ok || op.Username != username {
+		return nil
+	}
+	return op
+}
+
+// remove operations finished before OPERATION_TTL, caller has to hold mutex
+func (registry *OperationRegistry) prune() {
+	for id, op := range registry.operations {
+		op.mutex.Lock()
+		expired := op.Finished != nil && time.Since(*op.Finished) > OPERATION_TTL
+		op.mutex.Unlock()
+		if expired {
+			delete(registry.operations, id)
+		}
+	}
+}
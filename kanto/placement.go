@@ -0,0 +1,172 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for placement of couchdb pods on nodes
+// pod anti-affinity keyed on cluster_tag label keeps replicas of one cluster on different nodes,
+// optional zone spreading prefers different zones, placement is chosen by user on create
+// and saved in cluster service annotation, so pods added by scaling are placed same way
+// kubernetes 1.3 reads affinity only from alpha pod annotation, so it is set in pod template annotations
+package kanto
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+)
+
+const (
+	// replicas can share node
+	ANTI_AFFINITY_NONE = "none"
+	// scheduler tries to put replicas on different nodes, replicas share node when there are not enough nodes
+	ANTI_AFFINITY_PREFERRED = "preferred"
+	// replicas are never on same node, replica stays pending when there is no free node
+	ANTI_AFFINITY_REQUIRED = "required"
+
+	// annotation of cluster service with placement of cluster
+	ANNOTATION_PLACEMENT = "kanto/placement"
+	// weight of preferred anti-affinity terms, node spreading wins over zone spreading
+	ANTI_AFFINITY_NODE_WEIGHT = 100
+	ANTI_AFFINITY_ZONE_WEIGHT = 50
+)
+
+// placement of couchdb pods
+type ClusterPlacement struct {
+	// anti-affinity of replicas on nodes, one of ANTI_AFFINITY_*
+	AntiAffinity string `json:",omitempty"`
+	// prefer replicas in different zones, nil means not set
+	SpreadZones *bool `json:",omitempty"`
+}
+
+// placement of clusters created without placement, set in main.go from os env
+var DEFAULT_PLACEMENT = ClusterPlacement{AntiAffinity: ANTI_AFFINITY_PREFERRED}
+
+// parse placement from v0 form values anti_affinity, spread_zones
+// @param r *http.Request
+// @return *ClusterPlacement - nil if no value is set
+// @return error - invalid spread_zones
+func ParsePlacementForm(r *http.Request) (*ClusterPlacement, error) {
+	antiAffinity, spreadZones := r.FormValue("anti_affinity"), r.FormValue("spread_zones")
+	if antiAffinity == "" && spreadZones == "" {
+		return nil, nil
+	}
+	placement := &ClusterPlacement{AntiAffinity: antiAffinity}
+	if spreadZones != "" {
+		spread, err := strconv.ParseBool(spreadZones)
+		if err != nil {
+			ErrorLog("placement: ParsePlacementForm: invalid spread_zones: " + spreadZones)
+			return nil, err
+		}
+		placement.SpreadZones = &spread
+	}
+	return placement, nil
+}
+
+// merge placement, values set in changes replace values from base
+// @param base *ClusterPlacement - can be nil
+// @param changes *ClusterPlacement - can be nil
+// @return *ClusterPlacement - new struct, base and changes are not modified
+func MergePlacement(base *ClusterPlacement, changes *ClusterPlacement) *ClusterPlacement {
+	merged := &ClusterPlacement{}
+	for _, placement := range []*ClusterPlacement{base, changes} {
+		if placement == nil {
+			continue
+		}
+		if placement.AntiAffinity != "" {
+			merged.AntiAffinity = placement.AntiAffinity
+		}
+		if placement.SpreadZones != nil {
+			spread := *placement.SpreadZones
+			merged.SpreadZones = &spread
+		}
+	}
+	return merged
+}
+
+// check that anti-affinity is known
+// @param placement *ClusterPlacement
+// @return error - message for user
+func ValidatePlacement(placement *ClusterPlacement) error {
+	switch placement.AntiAffinity {
+	case ANTI_AFFINITY_NONE, ANTI_AFFINITY_PREFERRED, ANTI_AFFINITY_REQUIRED:
+		return nil
+	}
+	return errors.New("unknown anti-affinity " + placement.AntiAffinity + ", anti-affinity has to be one of none, preferred, required")
+}
+
+// placement saved in cluster service annotation
+// @param svc *api.Service - cluster service, can be nil
+// @return *ClusterPlacement - nil if service has no (valid) annotation
+func PlacementOf(svc *api.Service) *ClusterPlacement {
+	if svc == nil || svc.Annotations[ANNOTATION_PLACEMENT] == "" {
+		return nil
+	}
+	placement := &ClusterPlacement{}
+	if err := json.Unmarshal([]byte(svc.Annotations[ANNOTATION_PLACEMENT]), placement); err != nil {
+		ErrorLog("placement: PlacementOf: invalid annotation of service " + svc.Name)
+		ErrorLog(err)
+		return nil
+	}
+	return placement
+}
+
+// annotation value with cluster placement
+// @return string - empty if cluster has no placement
+func (cluster *CouchdbCluster) placementAnnotation() string {
+	if cluster.Placement == nil {
+		return ""
+	}
+	value, _ := json.Marshal(cluster.Placement)
+	return string(value)
+}
+
+// pod anti-affinity of cluster replicas, keyed on cluster_tag label in namespace of cluster
+// @return *api.Affinity - nil if cluster has no placement or placement has no rules
+func (cluster *CouchdbCluster) affinity() *api.Affinity {
+	if cluster.Placement == nil {
+		return nil
+	}
+	selector := &unversioned.LabelSelector{MatchLabels: map[string]string{LABEL_CLUSTER_TAG: cluster.Tag}}
+	antiAffinity := &api.PodAntiAffinity{}
+	nodeTerm := api.PodAffinityTerm{LabelSelector: selector, TopologyKey: unversioned.LabelHostname}
+	switch cluster.Placement.AntiAffinity {
+	case ANTI_AFFINITY_REQUIRED:
+		antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution = []api.PodAffinityTerm{nodeTerm}
+	case ANTI_AFFINITY_PREFERRED:
+		antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+			api.WeightedPodAffinityTerm{Weight: ANTI_AFFINITY_NODE_WEIGHT, PodAffinityTerm: nodeTerm})
+	}
+	// zones are usually fewer than replicas, so zone spreading is only preferred
+	if cluster.Placement.SpreadZones != nil && *cluster.Placement.SpreadZones {
+		zoneTerm := api.PodAffinityTerm{LabelSelector: selector, TopologyKey: unversioned.LabelZoneFailureDomain}
+		antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+			api.WeightedPodAffinityTerm{Weight: ANTI_AFFINITY_ZONE_WEIGHT, PodAffinityTerm: zoneTerm})
+	}
+	if len(antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution) == 0 &&
+		len(antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution) == 0 {
+		return nil
+	}
+	return &api.Affinity{PodAntiAffinity: antiAffinity}
+}
+
+// set cluster affinity annotation to pod template
+// @param template *api.PodTemplateSpec
+func (cluster *CouchdbCluster) applyPlacement(template *api.PodTemplateSpec) {
+	affinity := cluster.affinity()
+	if affinity == nil {
+		return
+	}
+	value, err := json.Marshal(affinity)
+	if err != nil {
+		ErrorLog("placement: applyPlacement: marshal affinity error")
+		ErrorLog(err)
+		return
+	}
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[api.AffinityAnnotationKey] = string(value)
+}
@@ -0,0 +1,79 @@
+package kanto
+
+import (
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+)
+
+func TestAffinity(t *testing.T) {
+	spread, noSpread := true, false
+	tests := []struct {
+		name      string
+		placement *ClusterPlacement
+		// topology keys of required and preferred terms, nil affinity has none
+		required  []string
+		preferred []string
+		weights   []int
+	}{
+		{"no placement", nil, nil, nil, nil},
+		{"none", &ClusterPlacement{AntiAffinity: ANTI_AFFINITY_NONE}, nil, nil, nil},
+		{"none without zones", &ClusterPlacement{AntiAffinity: ANTI_AFFINITY_NONE, SpreadZones: &noSpread}, nil, nil, nil},
+		{"preferred", &ClusterPlacement{AntiAffinity: ANTI_AFFINITY_PREFERRED},
+			nil, []string{unversioned.LabelHostname}, []int{ANTI_AFFINITY_NODE_WEIGHT}},
+		{"required", &ClusterPlacement{AntiAffinity: ANTI_AFFINITY_REQUIRED},
+			[]string{unversioned.LabelHostname}, nil, nil},
+		{"none with zones", &ClusterPlacement{AntiAffinity: ANTI_AFFINITY_NONE, SpreadZones: &spread},
+			nil, []string{unversioned.LabelZoneFailureDomain}, []int{ANTI_AFFINITY_ZONE_WEIGHT}},
+		{"preferred with zones", &ClusterPlacement{AntiAffinity: ANTI_AFFINITY_PREFERRED, SpreadZones: &spread},
+			nil, []string{unversioned.LabelHostname, unversioned.LabelZoneFailureDomain},
+			[]int{ANTI_AFFINITY_NODE_WEIGHT, ANTI_AFFINITY_ZONE_WEIGHT}},
+		{"required with zones", &ClusterPlacement{AntiAffinity: ANTI_AFFINITY_REQUIRED, SpreadZones: &spread},
+			[]string{unversioned.LabelHostname}, []string{unversioned.LabelZoneFailureDomain}, []int{ANTI_AFFINITY_ZONE_WEIGHT}},
+	}
+	for _, test := range tests {
+		cluster := &CouchdbCluster{Tag: "abcd", Placement: test.placement}
+		affinity := cluster.affinity()
+		if len(test.required) == 0 && len(test.preferred) == 0 {
+			if affinity != nil {
+				t.Errorf("%s: affinity = %+v, want nil", test.name, affinity)
+			}
+			continue
+		}
+		if affinity == nil || affinity.PodAntiAffinity == nil {
+			t.Errorf("%s: affinity = nil, want pod anti-affinity", test.name)
+			continue
+		}
+		antiAffinity := affinity.PodAntiAffinity
+
+		required := antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+		if len(required) != len(test.required) {
+			t.Errorf("%s: %d required terms, want %d", test.name, len(required), len(test.required))
+		}
+		for i := 0; i < len(required) && i < len(test.required); i++ {
+			checkAffinityTerm(t, test.name, required[i], test.required[i])
+		}
+
+		preferred := antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+		if len(preferred) != len(test.preferred) {
+			t.Errorf("%s: %d preferred terms, want %d", test.name, len(preferred), len(test.preferred))
+		}
+		for i := 0; i < len(preferred) && i < len(test.preferred); i++ {
+			checkAffinityTerm(t, test.name, preferred[i].PodAffinityTerm, test.preferred[i])
+			if int(preferred[i].Weight) != test.weights[i] {
+				t.Errorf("%s: preferred term %d weight = %d, want %d", test.name, i, preferred[i].Weight, test.weights[i])
+			}
+		}
+	}
+}
+
+// term selects pods of cluster "abcd" on topology key
+func checkAffinityTerm(t *testing.T, name string, term api.PodAffinityTerm, topologyKey string) {
+	if term.TopologyKey != topologyKey {
+		t.Errorf("%s: topology key = %s, want %s", name, term.TopologyKey, topologyKey)
+	}
+	if term.LabelSelector == nil || term.LabelSelector.MatchLabels[LABEL_CLUSTER_TAG] != "abcd" {
+		t.Errorf("%s: label selector = %+v, want %s=abcd", name, term.LabelSelector, LABEL_CLUSTER_TAG)
+	}
+}
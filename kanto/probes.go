@@ -0,0 +1,48 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for http probes of couchdb containers
+// readiness probe keeps starting pods out of cluster service and kanto waits for Ready condition of pods,
+// liveness probe restarts couchdb container that stopped answering
+package kanto
+
+import (
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+// couchdb path used by probes, couchdb welcome message is available without credentials
+const PROBE_PATH = "/"
+
+// timings of http probe, all values are in seconds except FailureThreshold
+type ProbeTimings struct {
+	// delay after container start before first probe
+	InitialDelaySeconds int32
+	// max time to wait for couchdb response
+	TimeoutSeconds int32
+	// interval between probes
+	PeriodSeconds int32
+	// failed probes in a row before pod is not ready (readiness) or container is restarted (liveness)
+	FailureThreshold int32
+}
+
+// readiness probe timings, can be overwritten by os ENVs "READINESS_PROBE_*"
+var READINESS_PROBE = ProbeTimings{InitialDelaySeconds: 5, TimeoutSeconds: 2, PeriodSeconds: 5, FailureThreshold: 3}
+
+// liveness probe timings, can be overwritten by os ENVs "LIVENESS_PROBE_*"
+// couchdb can be slow during compaction, so container is restarted only after longer outage
+var LIVENESS_PROBE = ProbeTimings{InitialDelaySeconds: 60, TimeoutSeconds: 5, PeriodSeconds: 10, FailureThreshold: 6}
+
+// http probe against couchdb port
+// @return *api.Probe
+func (timings ProbeTimings) Probe() *api.Probe {
+	probe := &api.Probe{
+		InitialDelaySeconds: timings.InitialDelaySeconds,
+		TimeoutSeconds:      timings.TimeoutSeconds,
+		PeriodSeconds:       timings.PeriodSeconds,
+		SuccessThreshold:    1,
+		FailureThreshold:    timings.FailureThreshold,
+	}
+	probe.HTTPGet = &api.HTTPGetAction{Path: PROBE_PATH, Port: intstr.FromInt(COUCHDB_PORT), Scheme: api.URISchemeHTTP}
+	return probe
+}
@@ -0,0 +1,445 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for replication reconciliation controller
+// pods and pod services of all clusters are watched, when pod is rescheduled (new pod, new ip)
+// replication of its cluster is configured again with stored database list
+package kanto
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/meta"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+const (
+	// reconcile states
+	RECONCILE_PENDING    = "pending"
+	RECONCILE_SYNCED     = "synced"
+	RECONCILE_WAITING    = "waiting for pods"
+	RECONCILE_FAILED     = "failed"
+	RECONCILE_NOT_NEEDED = "single replica"
+
+	// how often dirty clusters are reconciled
+	RECONCILE_INTERVAL = 2 * time.Second
+	// backoff after failed reconciliation, doubled after each failure
+	RECONCILE_MIN_BACKOFF = 5 * time.Second
+	RECONCILE_MAX_BACKOFF = 5 * time.Minute
+	// wait before watch is started again after error
+	RECONCILE_WATCH_RETRY = 5 * time.Second
+)
+
+// all clusters are checked again after this period, even without pod events
+// can be overwritten by os ENV "RECONCILE_RESYNC_PERIOD"
+var RECONCILE_RESYNC_PERIOD = 5 * time.Minute
+
+// number of clusters reconciled at same time, replication setup of unhealthy cluster can take minutes,
+// so it must not hold back other clusters, can be overwritten by os ENV "RECONCILE_WORKERS"
+var RECONCILE_WORKERS = 4
+
+// running reconciler, nil when reconciliation is disabled (os ENV "RECONCILE_REPLICATION=false")
+var RECONCILER *ReplicationReconciler
+
+// reconcile status of cluster, shown in cluster detail
+type ReconcileStatus struct {
+	State string `json:"state"`
+	// peer endpoints used in last successful replication setup
+	Endpoints []string `json:"endpoints,omitempty"`
+	// last successful replication setup
+	LastSynced *time.Time `json:"last_synced,omitempty"`
+	// error of last failed replication setup
+	LastError string `json:"last_error,omitempty"`
+	// failed attempts since last success
+	Failures int `json:"failures,omitempty"`
+	// next attempt after failure
+	NextRetry *time.Time `json:"next_retry,omitempty"`
+}
+
+// internal state of one cluster
+type reconcileState struct {
+	status ReconcileStatus
+	// endpoints and pod uids of last successful replication setup
+	fingerprint string
+	// cluster has to be checked
+	dirty bool
+	// cluster is being reconciled by worker, cluster is never reconciled by two workers
+	running bool
+}
+
+// controller that keeps replication configured when cluster pods change
+type ReplicationReconciler struct {
+	mutex    sync.Mutex
+	clusters map[string]*reconcileState
+	// keys of clusters for workers
+	queue chan string
+}
+
+// create reconciler and start watches and worker in background
+// @return *ReplicationReconciler
+func StartReconciler() *ReplicationReconciler {
+	r := &ReplicationReconciler{clusters: make(map[string]*reconcileState), queue: make(chan string)}
+	if KUBE_CACHE != nil {
+		// shared informers already watch pods and services
+		KUBE_CACHE.AddListener(r.enqueueObject)
+	} else {
+		go r.watchLoop("pods", LABEL_USER+","+LABEL_CLUSTER_TAG, r.listPods, r.watchPods)
+		go r.watchLoop("pod services", LABEL_POD_SERVICE+","+LABEL_USER+","+LABEL_CLUSTER_TAG, r.listServices, r.watchServices)
+	}
+	workers := RECONCILE_WORKERS
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go r.worker()
+	}
+	go r.dispatcher()
+	InfoLog("reconciler: replication reconciliation started")
+	return r
+}
+
+// key of cluster in reconciler
+func reconcileKey(namespace string, username string, tag string) string {
+	return namespace + "/" + username + "/" + tag
+}
+
+// get reconcile status of cluster
+// safe to call on nil reconciler
+// @param cluster *CouchdbCluster - required: namespace, username, tag
+// @return *ReconcileStatus - copy of status, nil if cluster was not seen by reconciler
+func (r *ReplicationReconciler) Status(cluster *CouchdbCluster) *ReconcileStatus {
+	if r == nil {
+		return nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	state, ok := r.clusters[reconcileKey(cluster.Namespace, cluster.Username, cluster.Tag)]
+	if !ok {
+		return nil
+	}
+	status := state.status
+	return &status
+}
+
+// save current pods as synced, called after replication was configured by operation
+// so reconciler does not configure it again
+// safe to call on nil reconciler
+// @param cluster *CouchdbCluster - required: namespace, username, tag, labels
+func (r *ReplicationReconciler) MarkSynced(cluster *CouchdbCluster) {
+	if r == nil {
+		return
+	}
+	fingerprint, endpoints, _, err := reconcileFingerprint(cluster)
+	if err != nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	state := r.state(reconcileKey(cluster.Namespace, cluster.Username, cluster.Tag))
+	state.synced(fingerprint, endpoints)
+}
+
+// mark cluster for reconciliation
+func (r *ReplicationReconciler) enqueue(key string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.state(key).dirty = true
+}
+
+// get or create state, caller has to hold mutex
+func (r *ReplicationReconciler) state(key string) *reconcileState {
+	state, ok := r.clusters[key]
+	if !ok {
+		state = &reconcileState{status: ReconcileStatus{State: RECONCILE_PENDING}}
+		r.clusters[key] = state
+	}
+	return state
+}
+
+// save successful replication setup, caller has to hold mutex
+func (state *reconcileState) synced(fingerprint string, endpoints []string) {
+	now := time.Now()
+	state.fingerprint = fingerprint
+	state.status = ReconcileStatus{State: RECONCILE_SYNCED, Endpoints: endpoints, LastSynced: &now}
+}
+
+// enqueue cluster of kubernetes object (pod or pod service)
+func (r *ReplicationReconciler) enqueueObject(obj runtime.Object) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	objLabels := accessor.GetLabels()
+	if objLabels[LABEL_USER] == "" || objLabels[LABEL_CLUSTER_TAG] == "" {
+		return
+	}
+	r.enqueue(reconcileKey(accessor.GetNamespace(), objLabels[LABEL_USER], objLabels[LABEL_CLUSTER_TAG]))
+}
+
+// list and watch objects forever, every listed and changed object enqueues its cluster
+// @param what string - description for logs
+// @param selector string - label selector
+// @param list func(api.ListOptions) ([]runtime.Object, string, error)
+// @param watchFrom func(api.ListOptions) (watch.Interface, error)
+func (r *ReplicationReconciler) watchLoop(what string, selector string,
+	list func(api.ListOptions) ([]runtime.Object, string, error), watchFrom func(api.ListOptions) (watch.Interface, error)) {
+	labelSelector, err := labels.Parse(selector)
+	if err != nil {
+		ErrorLog("reconciler: invalid selector for " + what)
+		ErrorLog(err)
+		return
+	}
+	for {
+		items, resourceVersion, err := list(api.ListOptions{LabelSelector: labelSelector})
+		if err != nil {
+			ErrorLog("reconciler: list " + what + " error")
+			ErrorLog(err)
+			time.Sleep(RECONCILE_WATCH_RETRY)
+			continue
+		}
+		for _, obj := range items {
+			r.enqueueObject(obj)
+		}
+		w, err := watchFrom(api.ListOptions{LabelSelector: labelSelector, ResourceVersion: resourceVersion})
+		if err != nil {
+			ErrorLog("reconciler: watch " + what + " error")
+			ErrorLog(err)
+			time.Sleep(RECONCILE_WATCH_RETRY)
+			continue
+		}
+		for event := range w.ResultChan() {
+			if event.Type == watch.Error {
+				break
+			}
+			r.enqueueObject(event.Object)
+		}
+		w.Stop()
+		DebugLog("reconciler: watch of " + what + " closed, starting again")
+	}
+}
+
+// list pods of all clusters in all namespaces
+func (r *ReplicationReconciler) listPods(options api.ListOptions) ([]runtime.Object, string, error) {
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		return nil, "", err
+	}
+	podList, err := c.Pods(api.NamespaceAll).List(options)
+	if err != nil {
+		return nil, "", err
+	}
+	items := []runtime.Object{}
+	for i := range podList.Items {
+		items = append(items, &podList.Items[i])
+	}
+	return items, podList.ResourceVersion, nil
+}
+
+// watch pods of all clusters in all namespaces
+func (r *ReplicationReconciler) watchPods(options api.ListOptions) (watch.Interface, error) {
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		return nil, err
+	}
+	return c.Pods(api.NamespaceAll).Watch(options)
+}
+
+// list pod services of all clusters in all namespaces
+func (r *ReplicationReconciler) listServices(options api.ListOptions) ([]runtime.Object, string, error) {
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		return nil, "", err
+	}
+	svcList, err := c.Services(api.NamespaceAll).List(options)
+	if err != nil {
+		return nil, "", err
+	}
+	items := []runtime.Object{}
+	for i := range svcList.Items {
+		items = append(items, &svcList.Items[i])
+	}
+	return items, svcList.ResourceVersion, nil
+}
+
+// watch pod services of all clusters in all namespaces
+func (r *ReplicationReconciler) watchServices(options api.ListOptions) (watch.Interface, error) {
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		return nil, err
+	}
+	return c.Services(api.NamespaceAll).Watch(options)
+}
+
+// send dirty clusters to workers, cluster already reconciled by other worker is sent after worker finishes
+// all clusters are marked dirty every RECONCILE_RESYNC_PERIOD
+func (r *ReplicationReconciler) dispatcher() {
+	ticker := time.NewTicker(RECONCILE_INTERVAL)
+	defer ticker.Stop()
+	lastResync := time.Now()
+	for range ticker.C {
+		now := time.Now()
+		resync := now.Sub(lastResync) >= RECONCILE_RESYNC_PERIOD
+		if resync {
+			lastResync = now
+		}
+		// collect clusters to reconcile
+		keys := []string{}
+		r.mutex.Lock()
+		for key, state := range r.clusters {
+			if resync {
+				state.dirty = true
+			}
+			if state.dirty && !state.running && (state.status.NextRetry == nil || !now.Before(*state.status.NextRetry)) {
+				state.running = true
+				keys = append(keys, key)
+			}
+		}
+		r.mutex.Unlock()
+		sort.Strings(keys)
+
+		// waits while all workers are busy
+		for _, key := range keys {
+			r.queue <- key
+		}
+	}
+}
+
+// reconcile clusters from queue, RECONCILE_WORKERS workers run in parallel
+func (r *ReplicationReconciler) worker() {
+	for key := range r.queue {
+		r.reconcile(key)
+		r.mutex.Lock()
+		// state of deleted cluster is removed by reconcile
+		if state, ok := r.clusters[key]; ok {
+			state.running = false
+		}
+		r.mutex.Unlock()
+	}
+}
+
+// reconcile one cluster, replication is configured again if cluster pods changed since last setup
+// @param key string - cluster key
+func (r *ReplicationReconciler) reconcile(key string) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) != 3 {
+		return
+	}
+	cluster := NewCouchdbCluster(parts[1], parts[2], parts[0])
+
+	// operation will configure replication itself, check cluster after it finishes
+	// operations started during reconcile wait until it finishes, so they cannot race replication setup
+	if !OPERATIONS.BeginReconcile(cluster.Username, cluster.Tag) {
+		return
+	}
+	defer OPERATIONS.EndReconcile(cluster.Username, cluster.Tag)
+	// cluster deleted
+	if _, err := cluster.GetClusterService(); err == ErrClusterNotFound {
+		r.mutex.Lock()
+		delete(r.clusters, key)
+		r.mutex.Unlock()
+		return
+	} else if err != nil {
+		r.failed(key, err)
+		return
+	}
+	if err := cluster.LoadReplicas(); err != nil {
+		r.failed(key, err)
+		return
+	}
+	fingerprint, endpoints, ready, err := reconcileFingerprint(cluster)
+	if err != nil {
+		r.failed(key, err)
+		return
+	}
+
+	r.mutex.Lock()
+	state := r.state(key)
+	state.dirty = false
+	if cluster.Replicas <= 1 {
+		state.status = ReconcileStatus{State: RECONCILE_NOT_NEEDED}
+		r.mutex.Unlock()
+		return
+	}
+	if !ready {
+		// next pod event marks cluster dirty again
+		state.status.State = RECONCILE_WAITING
+		r.mutex.Unlock()
+		return
+	}
+	if fingerprint == state.fingerprint {
+		state.status.State = RECONCILE_SYNCED
+		r.mutex.Unlock()
+		return
+	}
+	r.mutex.Unlock()
+
+	// pods changed, configure replication again with stored databases
+	InfoLog("reconciler: cluster pods changed, configuring replication for cluster_tag: " + cluster.Tag + ", namespace: " + cluster.Namespace)
+	databases, err := cluster.DatabasesToReplicate()
+	if err != nil {
+		r.failed(key, err)
+		return
+	}
+	if err := cluster.SetupReplication(databases); err != nil {
+		r.failed(key, err)
+		return
+	}
+	r.mutex.Lock()
+	r.state(key).synced(fingerprint, endpoints)
+	r.mutex.Unlock()
+}
+
+// save failed reconciliation and schedule retry with exponential backoff
+func (r *ReplicationReconciler) failed(key string, err error) {
+	ErrorLog("reconciler: reconcile of cluster " + key + " failed")
+	ErrorLog(err)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	state := r.state(key)
+	state.dirty = true
+	state.status.State = RECONCILE_FAILED
+	state.status.LastError = err.Error()
+	state.status.Failures++
+	backoff := RECONCILE_MIN_BACKOFF
+	for i := 1; i < state.status.Failures && backoff < RECONCILE_MAX_BACKOFF; i++ {
+		backoff *= 2
+	}
+	if backoff > RECONCILE_MAX_BACKOFF {
+		backoff = RECONCILE_MAX_BACKOFF
+	}
+	next := time.Now().Add(backoff)
+	state.status.NextRetry = &next
+}
+
+// fingerprint of cluster membership: peer endpoints and uids of pods
+// new pod (rescheduled or restarted by spawner) or new endpoint changes fingerprint
+// @param cluster *CouchdbCluster - required: labels, namespace, replicas
+// @return string - fingerprint
+// @return []string - peer endpoints
+// @return bool - true if all cluster.Replicas pods are running and ready
+// @return error
+func reconcileFingerprint(cluster *CouchdbCluster) (string, []string, bool, error) {
+	podList, err := cluster.GetPods()
+	if err != nil {
+		return "", nil, false, err
+	}
+	uids := []string{}
+	for _, pod := range *podList {
+		if pod.DeletionTimestamp == nil && api.IsPodReady(&pod) {
+			uids = append(uids, string(pod.UID))
+		}
+	}
+	sort.Strings(uids)
+	endpoints, err := cluster.PeerEndpoints()
+	if err != nil {
+		return "", nil, false, err
+	}
+	ready := len(uids) == int(cluster.Replicas) && len(endpoints) == int(cluster.Replicas)
+	return strings.Join(endpoints, ",") + "|" + strings.Join(uids, ","), endpoints, ready, nil
+}
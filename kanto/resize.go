@@ -0,0 +1,295 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for resizing volumes of running cluster
+// claims are resized one replica at a time, first in place (claim size is updated and volume is expanded by kubernetes),
+// storage that cannot expand in place is handled by moving replica to new bigger claim,
+// new claim is seeded by couchdb replication before next replica is touched
+package kanto
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/resource"
+	"k8s.io/kubernetes/pkg/labels"
+)
+
+// max time to wait for one expanded volume or one seeded replica, can be overwritten by os ENV "RESIZE_TIMEOUT"
+var RESIZE_TIMEOUT = 30 * time.Minute
+
+// error returned when kubernetes rejects new size of claim, storage has to be replaced instead
+var ErrExpansionNotSupported = errors.New("volume expansion is not supported by storage of claim")
+
+// new storage of cluster for resize request
+// size cannot be decreased and storage class cannot be changed, new size has to be within operator limits
+// @param current *ClusterStorage - storage saved in cluster service, nil for clusters created before storage was configurable
+// @param requested *ClusterStorage - requested storage, only size is used
+// @return *ClusterStorage - storage with new size and current class
+// @return error - message for user
+func ResizedStorage(current *ClusterStorage, requested *ClusterStorage) (*ClusterStorage, error) {
+	if requested == nil || requested.Size == nil {
+		return nil, errors.New("storage size is not set")
+	}
+	currentClass := ""
+	if current != nil {
+		currentClass = current.Class
+	}
+	if requested.Class != "" && requested.Class != currentClass {
+		return nil, errors.New("storage class cannot be changed")
+	}
+	currentSize := MergeStorage(&DEFAULT_STORAGE, current).Size
+	if requested.Size.Cmp(*currentSize) < 0 {
+		return nil, errors.New("storage size cannot be decreased, current size is " + currentSize.String())
+	}
+	// current class is allowed even if operator removed it from STORAGE_CLASSES
+	if err := ValidateStorage(&ClusterStorage{Size: requested.Size}); err != nil {
+		return nil, err
+	}
+	return &ClusterStorage{Size: requested.Size.Copy(), Class: currentClass}, nil
+}
+
+// check that cluster of spawner type has volumes to resize
+// claims of every spawner are expanded in place, only replacement of volumes (storage that cannot expand)
+// needs spawner implementing VolumeReplacer, that is checked during resize
+// @param spawnerType string
+// @return error - message for user
+func ResizeSupported(spawnerType string) error {
+	if _, err := GetSpawner(spawnerType); err != nil {
+		return err
+	}
+	if spawnerType == COMPONENT_DEPLOYMENT {
+		return errors.New("volumes of " + spawnerType + " cluster cannot be resized, cluster has no persistent volumes")
+	}
+	return nil
+}
+
+// resize volume claims of all replicas to cluster.Storage.Size
+// claims already at requested size are skipped, so failed resize can be started again
+// @param cluster *CouchdbCluster - required: tag, labels, namespace, username, storage with new size
+// @return error
+func (cluster *CouchdbCluster) ResizeCouchdbCluster() error {
+	cluster.Operation.StepRunning(STEP_VOLUMES_RESIZED)
+	if cluster.Storage == nil || cluster.Storage.Size == nil {
+		return errors.New("storage size is not set")
+	}
+	size := *cluster.Storage.Size
+	spawner, err := cluster.Spawner()
+	if err != nil {
+		return err
+	}
+	claims, err := cluster.GetVolumeClaims()
+	if err != nil {
+		return err
+	}
+	if len(claims) == 0 {
+		return errors.New("cluster has no persistent volumes")
+	}
+	// replica count and databases are needed to re-seed replaced replicas
+	err = cluster.LoadReplicas()
+	if err != nil {
+		ErrorLog("resize: ResizeCouchdbCluster: load replicas error")
+		return err
+	}
+	databases, err := cluster.DatabasesToReplicate()
+	if err != nil {
+		ErrorLog("resize: ResizeCouchdbCluster: load replicated databases error")
+		return err
+	}
+
+	inPlace := true
+	replaced := false
+	for i := range claims {
+		claim := &claims[i]
+		current := claim.Spec.Resources.Requests[api.ResourceStorage]
+		if current.Cmp(size) >= 0 {
+			continue
+		}
+		if inPlace {
+			err = cluster.expandVolumeClaim(claim, size)
+			if err == nil {
+				InfoLog("resize: claim " + claim.Name + " expanded to " + size.String())
+				continue
+			} else if err != ErrExpansionNotSupported {
+				return err
+			}
+			// other claims have same storage, do not try to expand them
+			InfoLog("resize: claim " + claim.Name + " cannot be expanded, replicas are moved to new claims")
+			inPlace = false
+		}
+		err = cluster.replaceReplicaVolume(spawner, claim, databases)
+		if err != nil {
+			return err
+		}
+		replaced = true
+	}
+
+	// save new size, claims of pods added by scaling are created with it
+	err = cluster.SaveStorage()
+	if err != nil {
+		return err
+	}
+	cluster.Operation.StepSucceeded(STEP_VOLUMES_RESIZED)
+	if !replaced {
+		// running pods were not touched
+		cluster.Operation.StepSkipped(STEP_PODS_READY)
+		cluster.Operation.StepSkipped(STEP_REPLICATION_CONFIGURED)
+	}
+	return nil
+}
+
+// get volume claims of cluster, sorted by name
+// @param cluster *CouchdbCluster - required: labels, namespace
+// @return []api.PersistentVolumeClaim
+// @return error
+func (cluster *CouchdbCluster) GetVolumeClaims() ([]api.PersistentVolumeClaim, error) {
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("resize: GetVolumeClaims: Cannot connect to Kubernetes api ")
+		return nil, err
+	}
+	listOptions := api.ListOptions{LabelSelector: labels.SelectorFromSet(labels.Set(cluster.Labels))}
+	pvcList, err := c.PersistentVolumeClaims(cluster.Namespace).List(listOptions)
+	if err != nil {
+		ErrorLog("resize: GetVolumeClaims: list pvc error")
+		return nil, err
+	}
+	claims := pvcList.Items
+	sort.Sort(claimsByName(claims))
+	return claims, nil
+}
+
+// save cluster.Storage to cluster service annotation
+// @param cluster *CouchdbCluster - required: tag, namespace, storage
+// @return error
+func (cluster *CouchdbCluster) SaveStorage() error {
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("resize: SaveStorage: Cannot connect to Kubernetes api ")
+		return err
+	}
+	// service is updated, so it is read from api instead of cache
+	svc, err := c.Services(cluster.Namespace).Get(CLUSTER_PREFIX + cluster.Tag)
+	if err != nil {
+		ErrorLog("resize: SaveStorage: get cluster service error")
+		return err
+	}
+	if svc.Annotations == nil {
+		svc.Annotations = map[string]string{}
+	}
+	svc.Annotations[ANNOTATION_STORAGE] = cluster.storageAnnotation()
+	_, err = c.Services(cluster.Namespace).Update(svc)
+	if err != nil {
+		ErrorLog("resize: SaveStorage: update cluster service error")
+		return err
+	}
+	return nil
+}
+
+// request bigger size of claim and wait until volume is expanded
+// @param claim *api.PersistentVolumeClaim
+// @param size resource.Quantity - new size
+// @return error - ErrExpansionNotSupported when kubernetes rejects new size
+func (cluster *CouchdbCluster) expandVolumeClaim(claim *api.PersistentVolumeClaim, size resource.Quantity) error {
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("resize: expandVolumeClaim: Cannot connect to Kubernetes api ")
+		return err
+	}
+	if claim.Spec.Resources.Requests == nil {
+		claim.Spec.Resources.Requests = api.ResourceList{}
+	}
+	claim.Spec.Resources.Requests[api.ResourceStorage] = size
+	_, err = c.PersistentVolumeClaims(cluster.Namespace).Update(claim)
+	if apierrors.IsInvalid(err) || apierrors.IsForbidden(err) {
+		// claim size is immutable or storage class does not allow expansion
+		DebugLog(err)
+		return ErrExpansionNotSupported
+	} else if err != nil {
+		ErrorLog("resize: expandVolumeClaim: update pvc error: " + claim.Name)
+		return err
+	}
+	return cluster.WaitForClaimCapacity(claim.Name, size, RESIZE_TIMEOUT)
+}
+
+// move replica to new claim, copy data to it via replication and delete old claim
+// @param spawner Spawner - spawner of cluster, has to implement VolumeReplacer
+// @param claim *api.PersistentVolumeClaim - claim of replica
+// @param databases []string - replicated databases
+// @return error
+func (cluster *CouchdbCluster) replaceReplicaVolume(spawner Spawner, claim *api.PersistentVolumeClaim, databases []string) error {
+	replacer, ok := spawner.(VolumeReplacer)
+	if !ok {
+		return errors.New("volumes of " + cluster.SpawnerType + " cluster cannot be expanded or replaced")
+	}
+	if cluster.Replicas < 2 {
+		// data of only replica would be lost
+		return errors.New("volume of cluster with one replica cannot be replaced, scale cluster up first")
+	}
+	peer, err := replacer.ReplaceVolumeClaim(cluster, claim)
+	if err != nil {
+		ErrorLog("resize: replaceReplicaVolume: replace claim error: " + claim.Name)
+		return err
+	}
+
+	// replica is empty, previous pod in replication circle copies data to it
+	err = cluster.SetupReplication(databases)
+	if err != nil {
+		return err
+	}
+	err = cluster.WaitForPeerSeeded(peer, databases, RESIZE_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	InfoLog("resize: replica " + peer + " moved to new claim, deleting old claim " + claim.Name)
+
+	// data are replicated, old claim is not needed
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("resize: replaceReplicaVolume: Cannot connect to Kubernetes api ")
+		return err
+	}
+	err = c.PersistentVolumeClaims(cluster.Namespace).Delete(claim.Name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		ErrorLog("resize: replaceReplicaVolume: delete old pvc error: " + claim.Name)
+		return err
+	}
+	return nil
+}
+
+// wait until replica has all documents of previous pod in replication circle
+// credentials are always loaded from secret, api handlers preset password to user token, which couchdb of clusters with secret rejects
+// @param peer string - peer endpoint of replica
+// @param databases []string - replicated databases
+// @param timeout time.Duration
+// @return error
+func (cluster *CouchdbCluster) WaitForPeerSeeded(peer string, databases []string, timeout time.Duration) error {
+	err := cluster.LoadCredentials()
+	if err != nil {
+		return err
+	}
+	peers, err := cluster.PeerEndpoints()
+	if err != nil {
+		return err
+	}
+	source := ""
+	for i := range peers {
+		if peers[i] == peer {
+			source = peers[(i+len(peers)-1)%len(peers)]
+		}
+	}
+	if source == "" {
+		return errors.New("replica " + peer + " is not in replication circle")
+	}
+	return cluster.WaitForReplicaSeeded(source, peer, databases, timeout)
+}
+
+// sort claims by name
+type claimsByName []api.PersistentVolumeClaim
+
+func (c claimsByName) Len() int           { return len(c) }
+func (c claimsByName) Less(i, j int) bool { return c[i].Name < c[j].Name }
+func (c claimsByName) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
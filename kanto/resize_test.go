@@ -0,0 +1,27 @@
+package kanto
+
+import "testing"
+
+func TestResizeSupported(t *testing.T) {
+	defer registerFakeSpawner(FAKE_SPAWNER, newFakeSpawner())()
+	defer registerFakeSpawner(FAKE_SPAWNER+"-volumes", fakeVolumeSpawner{newFakeSpawner()})()
+
+	tests := []struct {
+		spawnerType string
+		supported   bool
+	}{
+		// claims are expanded in place, VolumeReplacer is needed only when expansion fails
+		{FAKE_SPAWNER, true},
+		{FAKE_SPAWNER + "-volumes", true},
+		{COMPONENT_RC, true},
+		{COMPONENT_PETSET, true},
+		{COMPONENT_DEPLOYMENT, false},
+		{"unknown", false},
+	}
+	for _, test := range tests {
+		err := ResizeSupported(test.spawnerType)
+		if (err == nil) != test.supported {
+			t.Errorf("ResizeSupported(%q) error = %v, want supported %v", test.spawnerType, err, test.supported)
+		}
+	}
+}
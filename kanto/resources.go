@@ -0,0 +1,224 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for cpu and memory resources of couchdb containers
+// requests and limits are chosen by user on create or scale, bounded by operator configured min and max
+// and saved in cluster service annotation, so they are applied also to pods added by scaling
+package kanto
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// annotation of cluster service with resources of couchdb containers
+const ANNOTATION_RESOURCES = "kanto/resources"
+
+// min and max of each cpu and memory request and limit, set in main.go from os env
+// resource missing in list is not bounded
+var RESOURCES_MIN = api.ResourceList{}
+var RESOURCES_MAX = api.ResourceList{}
+
+// requests and limits of clusters created without resources, set in main.go from os env
+var DEFAULT_RESOURCES = api.ResourceRequirements{}
+
+// parse resources from v0 form values cpu_request, cpu_limit, memory_request, memory_limit
+// @param r *http.Request
+// @return *api.ResourceRequirements - nil if no value is set
+// @return error - invalid quantity
+func ParseResourcesForm(r *http.Request) (*api.ResourceRequirements, error) {
+	requests, err := ParseResourceList(map[api.ResourceName]string{
+		api.ResourceCPU:    r.FormValue("cpu_request"),
+		api.ResourceMemory: r.FormValue("memory_request"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	limits, err := ParseResourceList(map[api.ResourceName]string{
+		api.ResourceCPU:    r.FormValue("cpu_limit"),
+		api.ResourceMemory: r.FormValue("memory_limit"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(requests) == 0 && len(limits) == 0 {
+		return nil, nil
+	}
+	return &api.ResourceRequirements{Requests: requests, Limits: limits}, nil
+}
+
+// merge resources, values from changes replace values from base
+// @param base *api.ResourceRequirements - can be nil
+// @param changes *api.ResourceRequirements - can be nil
+// @return *api.ResourceRequirements - new struct, base and changes are not modified
+func MergeResources(base *api.ResourceRequirements, changes *api.ResourceRequirements) *api.ResourceRequirements {
+	merged := &api.ResourceRequirements{Requests: api.ResourceList{}, Limits: api.ResourceList{}}
+	for _, res := range []*api.ResourceRequirements{base, changes} {
+		if res == nil {
+			continue
+		}
+		for name, quantity := range res.Requests {
+			merged.Requests[name] = quantity
+		}
+		for name, quantity := range res.Limits {
+			merged.Limits[name] = quantity
+		}
+	}
+	return merged
+}
+
+// check that only cpu and memory are set, values are within RESOURCES_MIN and RESOURCES_MAX
+// and request is not bigger than limit
+// @param res *api.ResourceRequirements
+// @return error - message for user
+func ValidateResources(res *api.ResourceRequirements) error {
+	for _, list := range []api.ResourceList{res.Requests, res.Limits} {
+		for name, quantity := range list {
+			if name != api.ResourceCPU && name != api.ResourceMemory {
+				return errors.New("invalid resource " + string(name) + ", only cpu and memory can be set")
+			}
+			if min, ok := RESOURCES_MIN[name]; ok && quantity.Cmp(min) < 0 {
+				return errors.New(string(name) + " " + quantity.String() + " is lower than minimum " + min.String())
+			}
+			if max, ok := RESOURCES_MAX[name]; ok && quantity.Cmp(max) > 0 {
+				return errors.New(string(name) + " " + quantity.String() + " is higher than maximum " + max.String())
+			}
+		}
+	}
+	for name, request := range res.Requests {
+		if limit, ok := res.Limits[name]; ok && request.Cmp(limit) > 0 {
+			return errors.New(string(name) + " request " + request.String() + " is higher than limit " + limit.String())
+		}
+	}
+	return nil
+}
+
+// resources saved in cluster service annotation
+// @param svc *api.Service - cluster service, can be nil
+// @return *api.ResourceRequirements - nil if service has no (valid) annotation
+func ResourcesOf(svc *api.Service) *api.ResourceRequirements {
+	if svc == nil || svc.Annotations[ANNOTATION_RESOURCES] == "" {
+		return nil
+	}
+	res := &api.ResourceRequirements{}
+	if err := json.Unmarshal([]byte(svc.Annotations[ANNOTATION_RESOURCES]), res); err != nil {
+		ErrorLog("resources: ResourcesOf: invalid annotation of service " + svc.Name)
+		ErrorLog(err)
+		return nil
+	}
+	return res
+}
+
+// annotation value with cluster resources
+// @return string - empty if cluster has no resources
+func (cluster *CouchdbCluster) resourcesAnnotation() string {
+	if cluster.Resources == nil {
+		return ""
+	}
+	value, _ := json.Marshal(cluster.Resources)
+	return string(value)
+}
+
+// set cluster resources to couchdb container of pod template
+// @param template *api.PodTemplateSpec
+// @return bool - true if template was changed
+func (cluster *CouchdbCluster) applyResources(template *api.PodTemplateSpec) bool {
+	if cluster.Resources == nil {
+		return false
+	}
+	changed := false
+	containers := template.Spec.Containers
+	for i := range containers {
+		if !api.Semantic.DeepEqual(containers[i].Resources, *cluster.Resources) {
+			containers[i].Resources = *cluster.Resources
+			changed = true
+		}
+	}
+	return changed
+}
+
+// change resources of running cluster to cluster.Resources
+// cluster service annotation and pod templates of spawner are updated, nothing is done if resources are same
+// annotation is saved first and restored when templates cannot be updated, so they do not diverge
+// deployment replaces pods with rolling update, replication controllers replace pods one replica at a time,
+// pet set template cannot be changed
+// @param cluster *CouchdbCluster - required: tag, labels, namespace, resources
+// @return bool - true if resources were changed
+// @return error
+func (cluster *CouchdbCluster) UpdateResources() (bool, error) {
+	if cluster.Resources == nil {
+		return false, nil
+	}
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("resources: UpdateResources: Cannot connect to Kubernetes api ")
+		return false, err
+	}
+	// service is updated, so it is read from api instead of cache
+	svc, err := c.Services(cluster.Namespace).Get(CLUSTER_PREFIX + cluster.Tag)
+	if err != nil {
+		ErrorLog("resources: UpdateResources: get cluster service error")
+		return false, err
+	}
+	if api.Semantic.DeepEqual(ResourcesOf(svc), cluster.Resources) {
+		return false, nil
+	}
+	spawner, err := cluster.Spawner()
+	if err != nil {
+		return false, err
+	}
+	updater, ok := spawner.(PodTemplateUpdater)
+	if !ok {
+		return false, errors.New("resources of " + cluster.SpawnerType + " cluster cannot be changed")
+	}
+
+	// save resources, pods added by scaling use them
+	if svc.Annotations == nil {
+		svc.Annotations = map[string]string{}
+	}
+	oldResources := ResourcesOf(svc)
+	oldAnnotation, hadAnnotation := svc.Annotations[ANNOTATION_RESOURCES]
+	svc.Annotations[ANNOTATION_RESOURCES] = cluster.resourcesAnnotation()
+	svc, err = c.Services(cluster.Namespace).Update(svc)
+	if err != nil {
+		ErrorLog("resources: UpdateResources: update cluster service error")
+		return false, err
+	}
+
+	replaced, err := updater.UpdatePodTemplate(cluster, cluster.applyResources)
+	if err != nil {
+		ErrorLog("resources: UpdateResources: update pod template error")
+		// some templates can be saved, return annotation and templates to old resources
+		if hadAnnotation {
+			svc.Annotations[ANNOTATION_RESOURCES] = oldAnnotation
+		} else {
+			delete(svc.Annotations, ANNOTATION_RESOURCES)
+		}
+		if _, restoreErr := c.Services(cluster.Namespace).Update(svc); restoreErr != nil {
+			ErrorLog("resources: UpdateResources: restore cluster service annotation error")
+			ErrorLog(restoreErr)
+		}
+		if oldResources != nil {
+			oldCluster := *cluster
+			oldCluster.Resources = oldResources
+			if _, restoreErr := updater.UpdatePodTemplate(&oldCluster, oldCluster.applyResources); restoreErr != nil {
+				ErrorLog("resources: UpdateResources: restore pod template error")
+				ErrorLog(restoreErr)
+			}
+		}
+		return false, err
+	}
+
+	// running pods of replication controllers keep old resources until they are replaced
+	if roller, ok := spawner.(PodRoller); ok && !replaced {
+		err = roller.RollPods(cluster)
+		if err != nil {
+			ErrorLog("resources: UpdateResources: replace pods error")
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,93 @@
+package kanto
+
+import (
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
+)
+
+func resourceList(cpu string, memory string) api.ResourceList {
+	list := api.ResourceList{}
+	if cpu != "" {
+		list[api.ResourceCPU] = resource.MustParse(cpu)
+	}
+	if memory != "" {
+		list[api.ResourceMemory] = resource.MustParse(memory)
+	}
+	return list
+}
+
+func TestValidateResources(t *testing.T) {
+	defer func(min api.ResourceList, max api.ResourceList) {
+		RESOURCES_MIN, RESOURCES_MAX = min, max
+	}(RESOURCES_MIN, RESOURCES_MAX)
+	RESOURCES_MIN = resourceList("100m", "128Mi")
+	RESOURCES_MAX = resourceList("2", "4Gi")
+
+	tests := []struct {
+		name  string
+		res   api.ResourceRequirements
+		valid bool
+	}{
+		{"empty", api.ResourceRequirements{}, true},
+		{"within bounds", api.ResourceRequirements{Requests: resourceList("500m", "512Mi"), Limits: resourceList("1", "1Gi")}, true},
+		{"at bounds", api.ResourceRequirements{Requests: resourceList("100m", "128Mi"), Limits: resourceList("2", "4Gi")}, true},
+		{"request below minimum", api.ResourceRequirements{Requests: resourceList("50m", "")}, false},
+		{"limit above maximum", api.ResourceRequirements{Limits: resourceList("", "8Gi")}, false},
+		{"request above limit", api.ResourceRequirements{Requests: resourceList("1", ""), Limits: resourceList("500m", "")}, false},
+		{"unknown resource", api.ResourceRequirements{Requests: api.ResourceList{"nvidia.com/gpu": resource.MustParse("1")}}, false},
+	}
+	for _, test := range tests {
+		err := ValidateResources(&test.res)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: ValidateResources() error = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestMergeResources(t *testing.T) {
+	base := &api.ResourceRequirements{Requests: resourceList("500m", "512Mi"), Limits: resourceList("1", "1Gi")}
+	tests := []struct {
+		name     string
+		base     *api.ResourceRequirements
+		changes  *api.ResourceRequirements
+		requests api.ResourceList
+		limits   api.ResourceList
+	}{
+		{"both nil", nil, nil, resourceList("", ""), resourceList("", "")},
+		{"no changes", base, nil, resourceList("500m", "512Mi"), resourceList("1", "1Gi")},
+		{"no base", nil, base, resourceList("500m", "512Mi"), resourceList("1", "1Gi")},
+		{"change replaces only set values", base, &api.ResourceRequirements{Limits: resourceList("", "2Gi")},
+			resourceList("500m", "512Mi"), resourceList("1", "2Gi")},
+	}
+	for _, test := range tests {
+		merged := MergeResources(test.base, test.changes)
+		if !equalResourceLists(merged.Requests, test.requests) {
+			t.Errorf("%s: requests = %v, want %v", test.name, merged.Requests, test.requests)
+		}
+		if !equalResourceLists(merged.Limits, test.limits) {
+			t.Errorf("%s: limits = %v, want %v", test.name, merged.Limits, test.limits)
+		}
+	}
+
+	// merge does not modify its arguments
+	MergeResources(base, &api.ResourceRequirements{Requests: resourceList("2", "")})
+	if cpu := base.Requests[api.ResourceCPU]; cpu.Cmp(resource.MustParse("500m")) != 0 {
+		t.Errorf("MergeResources() modified base cpu request to %s", cpu.String())
+	}
+}
+
+// quantities are compared by value, "1" and "1000m" are equal
+func equalResourceLists(a api.ResourceList, b api.ResourceList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, quantity := range a {
+		other, ok := b[name]
+		if !ok || quantity.Cmp(other) != 0 {
+			return false
+		}
+	}
+	return true
+}
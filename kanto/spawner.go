@@ -0,0 +1,190 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for pod spawners
+// spawner is kubernetes component that runs couchdb pods of cluster (deployment, replication controllers, pet set)
+// every spawner implements Spawner interface and is registered under its type name,
+// cluster operations use spawner from registry instead of checking spawner type
+package kanto
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// pod spawner of couchdb cluster
+type Spawner interface {
+	// create spawner with cluster.Replicas pods
+	// @param cluster *CouchdbCluster - required: tag, labels, namespace, replicas
+	// @return error
+	Create(cluster *CouchdbCluster) error
+	// delete spawner and all its components (replica sets, pvc, pod services, ...)
+	// pods are deleted by DeleteCouchdbCluster
+	// @param cluster *CouchdbCluster - required: tag, labels, namespace
+	// @return error
+	Delete(cluster *CouchdbCluster) error
+	// change number of pods to cluster.Replicas, replication is not configured here
+	// @param cluster *CouchdbCluster - required: tag, labels, namespace, replicas
+	// @return error
+	Scale(cluster *CouchdbCluster) error
+	// get current number of replicas
+	// @param cluster *CouchdbCluster - required: tag, labels, namespace
+	// @return int32
+	// @return error
+	CurrentReplicas(cluster *CouchdbCluster) (int32, error)
+	// get addresses (ip or hostname) of all couchdb pods used for replication
+	// order is stable, replication circle is configured in this order
+	// @param cluster *CouchdbCluster - required: tag, labels, namespace
+	// @return []string
+	// @return error
+	PeerEndpoints(cluster *CouchdbCluster) ([]string, error)
+}
+
+// spawner that can change pod template of running cluster
+type PodTemplateUpdater interface {
+	// apply update to pod templates of cluster, templates are saved only when update returns true
+	// @param cluster *CouchdbCluster - required: tag, labels, namespace
+	// @param update func(*api.PodTemplateSpec) bool - changes template, returns true if template was changed
+	// @return bool - true if running pods were replaced
+	// @return error
+	UpdatePodTemplate(cluster *CouchdbCluster, update func(*api.PodTemplateSpec) bool) (bool, error)
+}
+
+// spawner whose running pods keep old pod template until they are replaced
+type PodRoller interface {
+	// replace running pods one replica at a time, next replica is replaced
+	// only after previous pod is ready and has all documents of its replication peer
+	// @param cluster *CouchdbCluster - required: tag, labels, namespace, username
+	// @return error
+	RollPods(cluster *CouchdbCluster) error
+}
+
+// spawner that can move replica to new empty volume claim
+type VolumeReplacer interface {
+	// recreate pod of replica that uses claim with new claim created from cluster.Storage, old claim is not deleted
+	// @param cluster *CouchdbCluster - required: tag, labels, namespace, storage
+	// @param claim *api.PersistentVolumeClaim - claim of replica
+	// @return string - peer endpoint of replica, same as in PeerEndpoints
+	// @return error
+	ReplaceVolumeClaim(cluster *CouchdbCluster, claim *api.PersistentVolumeClaim) (string, error)
+}
+
+// annotation of cluster service with spawner type of cluster
+const ANNOTATION_SPAWNER_TYPE = "kanto/spawner-type"
+
+// error returned for unknown spawner type
+var ErrUnknownSpawner = errors.New("unknown spawner type")
+
+// registry of spawners by type
+var spawners = map[string]Spawner{
+	COMPONENT_DEPLOYMENT: &DeploymentSpawner{},
+	COMPONENT_RC:         &RCSpawner{},
+	COMPONENT_PETSET:     &PetSetSpawner{},
+}
+var spawnersMutex sync.RWMutex
+
+// register spawner under type name, existing spawner with same name is replaced
+// @param name string - spawner type, value of SPAWNER_TYPE
+// @param spawner Spawner
+func RegisterSpawner(name string, spawner Spawner) {
+	spawnersMutex.Lock()
+	defer spawnersMutex.Unlock()
+	spawners[name] = spawner
+}
+
+// get spawner by type name
+// @param name string - spawner type
+// @return Spawner
+// @return error - ErrUnknownSpawner if no spawner is registered with this name
+func GetSpawner(name string) (Spawner, error) {
+	spawnersMutex.RLock()
+	defer spawnersMutex.RUnlock()
+	spawner, ok := spawners[name]
+	if !ok {
+		return nil, ErrUnknownSpawner
+	}
+	return spawner, nil
+}
+
+// get spawner of cluster, spawner type is loaded from cluster service if not set
+// @return Spawner
+// @return error
+func (cluster *CouchdbCluster) Spawner() (Spawner, error) {
+	if cluster.SpawnerType == "" {
+		if err := cluster.LoadSpawnerType(); err != nil {
+			return nil, err
+		}
+	}
+	spawner, err := GetSpawner(cluster.SpawnerType)
+	if err != nil {
+		ErrorLog("spawner: unknown spawner type: " + cluster.SpawnerType + ", cluster_tag: " + cluster.Tag)
+	}
+	return spawner, err
+}
+
+// load spawner type from annotation of cluster service and save it to cluster.SpawnerType
+// clusters created before spawner type was saved (or without service) use SPAWNER_TYPE
+// @param cluster *CouchdbCluster - required: tag, labels, namespace
+// @return error
+func (cluster *CouchdbCluster) LoadSpawnerType() error {
+	svc, err := cluster.GetClusterService()
+	if err != nil && err != ErrClusterNotFound {
+		ErrorLog("spawner: LoadSpawnerType: get cluster service error")
+		return err
+	}
+	cluster.SpawnerType = SpawnerTypeOf(svc)
+	return nil
+}
+
+// spawner type saved in cluster service annotation
+// @param svc *api.Service - cluster service, can be nil
+// @return string - annotation value or SPAWNER_TYPE if service has no annotation
+func SpawnerTypeOf(svc *api.Service) string {
+	if svc != nil && svc.Annotations[ANNOTATION_SPAWNER_TYPE] != "" {
+		return svc.Annotations[ANNOTATION_SPAWNER_TYPE]
+	}
+	return SPAWNER_TYPE
+}
+
+// get addresses of all couchdb pods from cluster spawner
+// @return []string
+// @return error
+func (cluster *CouchdbCluster) PeerEndpoints() ([]string, error) {
+	spawner, err := cluster.Spawner()
+	if err != nil {
+		return nil, err
+	}
+	return spawner.PeerEndpoints(cluster)
+}
+
+// ip addresses of ready cluster pods, sorted by pod name
+// used by spawners without stable pod addresses
+// @param cluster *CouchdbCluster - required: labels, namespace
+// @return []string
+// @return error
+func (cluster *CouchdbCluster) podIPs() ([]string, error) {
+	podList, err := cluster.GetPods()
+	if err != nil {
+		ErrorLog("spawner: podIPs: get pods error")
+		return nil, err
+	}
+	pods := *podList
+	sort.Sort(podsByName(pods))
+	ips := []string{}
+	for _, pod := range pods {
+		if api.IsPodReady(&pod) && pod.Status.PodIP != "" {
+			ips = append(ips, pod.Status.PodIP)
+		}
+	}
+	return ips, nil
+}
+
+// sort pods by name
+type podsByName []api.Pod
+
+func (p podsByName) Len() int           { return len(p) }
+func (p podsByName) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p podsByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
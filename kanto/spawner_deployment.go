@@ -3,6 +3,7 @@ package kanto
 import (
 	"k8s.io/kubernetes/pkg/apis/extensions"
 	"k8s.io/kubernetes/pkg/api"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
 	"k8s.io/kubernetes/pkg/labels"
 	"strings"
 	"errors"
@@ -100,8 +101,9 @@ func (cluster *CouchdbCluster) DeleteDeployment() (error) {
 		return err
 	}
 	// delete deployment
+	// deployment can be already deleted by previous failed delete
 	err = c.Deployments(cluster.Namespace).Delete(CLUSTER_PREFIX+cluster.Tag, &deleteOptions)
-	if err != nil {
+	if err != nil && !apierrors.IsNotFound(err) {
 		ErrorLog("kube control : delete coucdb cluster: delete deployment error")
 		return err
 	}
@@ -122,25 +124,23 @@ func (cluster *CouchdbCluster) DeleteDeployment() (error) {
 		ErrorLog("kube control : delete coucdb cluster: list replica sets error")
 		return err
 	}
-	// iterate thorough all replica sets and find matching
+	// iterate thorough all replica sets and delete matching
+	// rolled deployment (resources or credentials change) leaves old replica sets, all of them are deleted
 	for _, replicaSet := range replicaSetLists.Items {
 		// check matching name
 		if strings.HasPrefix(replicaSet.Name, CLUSTER_PREFIX + cluster.Tag) {
-			// we got our replica set, so delete it
 			err = c2.ReplicaSets(cluster.Namespace).Delete(replicaSet.Name, &deleteOptions)
-			if err != nil {
+			if err != nil && !apierrors.IsNotFound(err) {
 				ErrorLog("kube control : delete coucdb cluster: delete replica set error")
 				return err
 			}
-			// there is only 1 replica set for each cluster db, so break loop
-			break
 		}
 	}
-	// no errors
-	return nil
+	// replica set could still spawn pods, wait until it is gone
+	return cluster.WaitForReplicaSetsDeleted(DELETE_TIMEOUT)
 }
 
-// scale couchdb cluster to new replica number
+// scale deployment to new replica number
 // @param cluster *CouchdbCluster - coucbdb cluster with new replica number
 // @param oldDeployment  *extensions.Deployment - deployment with old replica number,  fetched via GetDeployment()
 func (cluster *CouchdbCluster) ScaleDeployment(oldDeployment *extensions.Deployment) (error){
@@ -159,13 +159,70 @@ func (cluster *CouchdbCluster) ScaleDeployment(oldDeployment *extensions.Deploym
 		ErrorLog("kube control : ScaleDeployment: deployment update error")
 		return err
 	}
+	//everything OK
+	return nil
+}
+
+// spawner with one deployment for cluster, pods do not have persistent volumes
+// replication is configured via pod IPs, which change when pod is recreated
+type DeploymentSpawner struct{}
 
-	// we need to reconfigure replication
-	err = cluster.SetupReplication(DatabasesToReplicate(cluster.Username))
+func (s *DeploymentSpawner) Create(cluster *CouchdbCluster) (error) {
+	deployment, err := cluster.CreateDeployment()
 	if err != nil {
-		ErrorLog("kube control : ScaleDeployment: reconfigure replication error")
 		return err
 	}
-	//everything OK
+	// deployment is deleted together with its replica sets
+	cluster.Transaction.Track("deployment "+deployment.Name, cluster.DeleteDeployment)
 	return nil
-}
\ No newline at end of file
+}
+
+func (s *DeploymentSpawner) Delete(cluster *CouchdbCluster) (error) {
+	return cluster.DeleteDeployment()
+}
+
+func (s *DeploymentSpawner) Scale(cluster *CouchdbCluster) (error) {
+	deployment, err := cluster.GetDeployment()
+	if err != nil {
+		return err
+	}
+	return cluster.ScaleDeployment(deployment)
+}
+
+func (s *DeploymentSpawner) CurrentReplicas(cluster *CouchdbCluster) (int32, error) {
+	// cached deployment
+	if deployment, ok := cluster.cache().Deployment(cluster.Namespace, CLUSTER_PREFIX+cluster.Tag); ok && deployment != nil {
+		return deployment.Spec.Replicas, nil
+	}
+	deployment, err := cluster.GetDeployment()
+	if err != nil {
+		return 0, err
+	}
+	return deployment.Spec.Replicas, nil
+}
+
+func (s *DeploymentSpawner) PeerEndpoints(cluster *CouchdbCluster) ([]string, error) {
+	return cluster.podIPs()
+}
+
+// update deployment pod template, deployment replaces all pods with rolling update
+func (s *DeploymentSpawner) UpdatePodTemplate(cluster *CouchdbCluster, update func(*api.PodTemplateSpec) bool) (bool, error) {
+	deployment, err := cluster.GetDeployment()
+	if err != nil {
+		return false, err
+	}
+	if !update(&deployment.Spec.Template) {
+		return false, nil
+	}
+	c, err := KubeClientExtensions(KUBE_API)
+	if err != nil {
+		ErrorLog("spawner_deployment: UpdatePodTemplate: kube extensions client error")
+		return false, err
+	}
+	_, err = c.Deployments(cluster.Namespace).Update(deployment)
+	if err != nil {
+		ErrorLog("spawner_deployment: UpdatePodTemplate: deployment update error")
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,136 @@
+package kanto
+
+import (
+	"errors"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// spawner type of fake spawners registered in tests
+const FAKE_SPAWNER = "fake"
+
+// spawner without kubernetes, keeps replicas in memory and records calls
+type fakeSpawner struct {
+	replicas map[string]int32
+	calls    []string
+	// returned by every call when set
+	err error
+}
+
+func newFakeSpawner() *fakeSpawner {
+	return &fakeSpawner{replicas: make(map[string]int32)}
+}
+
+func (s *fakeSpawner) record(call string, cluster *CouchdbCluster) error {
+	s.calls = append(s.calls, call+" "+cluster.Tag)
+	return s.err
+}
+
+func (s *fakeSpawner) Create(cluster *CouchdbCluster) error {
+	if err := s.record("create", cluster); err != nil {
+		return err
+	}
+	s.replicas[cluster.Tag] = cluster.Replicas
+	return nil
+}
+
+func (s *fakeSpawner) Delete(cluster *CouchdbCluster) error {
+	if err := s.record("delete", cluster); err != nil {
+		return err
+	}
+	delete(s.replicas, cluster.Tag)
+	return nil
+}
+
+func (s *fakeSpawner) Scale(cluster *CouchdbCluster) error {
+	if err := s.record("scale", cluster); err != nil {
+		return err
+	}
+	s.replicas[cluster.Tag] = cluster.Replicas
+	return nil
+}
+
+func (s *fakeSpawner) CurrentReplicas(cluster *CouchdbCluster) (int32, error) {
+	if err := s.record("replicas", cluster); err != nil {
+		return 0, err
+	}
+	return s.replicas[cluster.Tag], nil
+}
+
+func (s *fakeSpawner) PeerEndpoints(cluster *CouchdbCluster) ([]string, error) {
+	if err := s.record("peers", cluster); err != nil {
+		return nil, err
+	}
+	peers := []string{}
+	for i := int32(0); i < s.replicas[cluster.Tag]; i++ {
+		peers = append(peers, cluster.Tag+"-"+string('a'+rune(i)))
+	}
+	return peers, nil
+}
+
+// fake spawner that can also replace volumes
+type fakeVolumeSpawner struct {
+	*fakeSpawner
+}
+
+func (s fakeVolumeSpawner) ReplaceVolumeClaim(cluster *CouchdbCluster, claim *api.PersistentVolumeClaim) (string, error) {
+	if err := s.record("replace "+claim.Name, cluster); err != nil {
+		return "", err
+	}
+	return cluster.Tag + "-a", nil
+}
+
+// register spawner for one test
+// @return func() - restores previous spawner, call it deferred
+func registerFakeSpawner(name string, spawner Spawner) func() {
+	previous, err := GetSpawner(name)
+	RegisterSpawner(name, spawner)
+	return func() {
+		if err != nil {
+			spawnersMutex.Lock()
+			delete(spawners, name)
+			spawnersMutex.Unlock()
+			return
+		}
+		RegisterSpawner(name, previous)
+	}
+}
+
+func TestClusterSpawner(t *testing.T) {
+	spawner := newFakeSpawner()
+	defer registerFakeSpawner(FAKE_SPAWNER, spawner)()
+
+	cluster := &CouchdbCluster{Tag: "abcd", Replicas: 3, SpawnerType: FAKE_SPAWNER}
+	s, err := cluster.Spawner()
+	if err != nil {
+		t.Fatalf("Spawner() error: %v", err)
+	}
+	if err := s.Create(cluster); err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	peers, err := cluster.PeerEndpoints()
+	if err != nil {
+		t.Fatalf("PeerEndpoints() error: %v", err)
+	}
+	if len(peers) != 3 || peers[0] != "abcd-a" || peers[2] != "abcd-c" {
+		t.Errorf("PeerEndpoints() = %v, want [abcd-a abcd-b abcd-c]", peers)
+	}
+
+	cluster.SpawnerType = "unknown"
+	if _, err := cluster.Spawner(); err != ErrUnknownSpawner {
+		t.Errorf("Spawner() of unknown type error = %v, want ErrUnknownSpawner", err)
+	}
+}
+
+func TestFakeSpawnerError(t *testing.T) {
+	spawner := newFakeSpawner()
+	spawner.err = errors.New("kubernetes is down")
+	cluster := &CouchdbCluster{Tag: "abcd", Replicas: 2}
+	if err := spawner.Scale(cluster); err != spawner.err {
+		t.Errorf("Scale() error = %v, want %v", err, spawner.err)
+	}
+	if replicas := spawner.replicas[cluster.Tag]; replicas != 0 {
+		t.Errorf("failed Scale() changed replicas to %d", replicas)
+	}
+}
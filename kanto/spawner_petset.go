@@ -1,10 +1,13 @@
 package kanto
 
 import (
+	"strconv"
+	"strings"
+
 	"k8s.io/kubernetes/pkg/apis/apps"
 	"k8s.io/kubernetes/pkg/api/unversioned"
 	"k8s.io/kubernetes/pkg/api"
-	"k8s.io/kubernetes/pkg/api/resource"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
 )
 
 /*
@@ -13,6 +16,17 @@ import (
 	// TESTING - ALPHA
 */
 
+const (
+	// suffix of headless service which gives pets stable dns names
+	PETSET_SERVICE_SUFFIX = "-pets"
+	// label of headless service, so it is not listed as couchdb cluster
+	LABEL_PETSET_SERVICE = "petset_service"
+)
+
+// kubernetes cluster dns domain, can be overwritten by os ENV "CLUSTER_DOMAIN"
+// pet dns names are resolvable only inside kubernetes cluster, kanto has to run there
+var CLUSTER_DOMAIN string = "cluster.local"
+
 // PetSet - available only in kubernetes v1.3+
 // big advantage: it allow create persistentVolume template
 // this template can create persistent volumeClaim for each POD automatically
@@ -24,34 +38,19 @@ import (
 // @return error - errors that occur during creation
 //
 func (cluster * CouchdbCluster) CreatePetSet() (*apps.PetSet, error) {
-	/*
-	PET SET, included in kube 1.3+
-	http://kubernetes-v1-3.github.io/docs/user-guide/petset/
-	// TESTING - ALPHA
-	*/
-	// pod template with volumes
-	podTemplate := *cluster.CouchdbPodTemplate(true, CLUSTER_PREFIX+cluster.Tag)
+	// pod template with volume mount, volume is created from claim template
+	podTemplate := *cluster.CouchdbPodTemplate(true, "")
 	// pet set spec label selector
 	lSelector := unversioned.LabelSelector{MatchLabels: cluster.Labels}
 
-	// pvc claim
-	pvc := api.PersistentVolumeClaim{}
+	// pvc claim template, claim of each pet is named {template}-{petset}-{ordinal}
+	// size and storage class are same as claims of rc spawner
+	pvc := cluster.volumeClaim()
 	pvc.Name = CLUSTER_PREFIX + cluster.Tag
-	pvc.Annotations = make(map[string]string)
-	pvc.Annotations["volume.alpha.kubernetes.io/storage-class"] = "anything"
-
-	// resource list for pvc claim template
-	rsList := make(api.ResourceList)
-	// SIZE
-	rsList[api.ResourceStorage] = *(resource.NewQuantity(5*1024*1024*1024, resource.BinarySI))
-	// pvc SPEC
-	pvcSpec := api.PersistentVolumeClaimSpec{}
-	pvcSpec.Resources.Requests = api.ResourceList(rsList)
-
-	pvc.Spec = pvcSpec
 	// pet set specs
 	petSetSPec := apps.PetSetSpec{Replicas: int(cluster.Replicas), Template: podTemplate,
-				Selector: &lSelector, VolumeClaimTemplates: []api.PersistentVolumeClaim{pvc}}
+				Selector: &lSelector, VolumeClaimTemplates: []api.PersistentVolumeClaim{pvc},
+				ServiceName: cluster.PetSetServiceName()}
 
 	// pet set
 	petSet := apps.PetSet{Spec:petSetSPec}
@@ -70,3 +69,203 @@ func (cluster * CouchdbCluster) CreatePetSet() (*apps.PetSet, error) {
 		return c.PetSets(cluster.Namespace).Create(&petSet)
 	}
 }
+
+// name of headless service for pets of cluster
+func (cluster *CouchdbCluster) PetSetServiceName() string {
+	return CLUSTER_PREFIX + cluster.Tag + PETSET_SERVICE_SUFFIX
+}
+
+// create headless service for pet set, it creates dns record for each pet
+// {petset}-{ordinal}.{service}.{namespace}.svc.{CLUSTER_DOMAIN}
+// @param cluster *CouchdbCluster - required: tag, labels, namespace
+// @return *api.Service - created service
+// @return error
+func (cluster *CouchdbCluster) CreatePetSetService() (*api.Service, error) {
+	serviceLabels := make(map[string]string)
+	for k, v := range cluster.Labels {
+		serviceLabels[k] = v
+	}
+	serviceLabels[LABEL_PETSET_SERVICE] = "true"
+
+	svcPorts := api.ServicePort{Port: COUCHDB_PORT}
+	// headless service, no cluster ip
+	serviceSpec := api.ServiceSpec{Selector: cluster.Labels, Ports: []api.ServicePort{svcPorts}, ClusterIP: api.ClusterIPNone}
+	service := api.Service{Spec: serviceSpec}
+	service.Name = cluster.PetSetServiceName()
+	service.Labels = serviceLabels
+
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("spawner_petset: CreatePetSetService: Cannot connect to Kubernetes api ")
+		ErrorLog(err)
+		return nil, err
+	}
+	return c.Services(cluster.Namespace).Create(&service)
+}
+
+// dns name of pet with ordinal
+// @param ordinal int
+// @return string
+func (cluster *CouchdbCluster) PetHostname(ordinal int) string {
+	return CLUSTER_PREFIX + cluster.Tag + "-" + strconv.Itoa(ordinal) + "." + cluster.PetSetServiceName() + "." +
+		cluster.Namespace + ".svc." + CLUSTER_DOMAIN
+}
+
+// delete pet set, its headless service and all claims created from claim template
+// pet set is scaled to 0 first and pets are deleted, pet set deletion does not delete pets
+// @param cluster *CouchdbCluster - required: tag, labels, namespace
+// @return error
+func (cluster *CouchdbCluster) DeletePetSet() (error) {
+	c, err := KubeClientApps(KUBE_API)
+	if err != nil {
+		ErrorLog("spawner_petset: DeletePetSet: Cannot connect to Kubernetes api ")
+		return err
+	}
+	petSet, err := c.PetSets(cluster.Namespace).Get(CLUSTER_PREFIX + cluster.Tag)
+	if err != nil && !apierrors.IsNotFound(err) {
+		ErrorLog("spawner_petset: DeletePetSet: get pet set error")
+		return err
+	}
+	if err == nil {
+		// scale to 0, so pet set does not recreate deleted pets
+		petSet.Spec.Replicas = 0
+		_, err = c.PetSets(cluster.Namespace).Update(petSet)
+		if err != nil {
+			ErrorLog("spawner_petset: DeletePetSet: scale to 0 error")
+			return err
+		}
+		// claim should not be deleted while pod is using it
+		err = cluster.WaitForPodsDeleted(DELETE_TIMEOUT)
+		if err != nil {
+			ErrorLog("spawner_petset: DeletePetSet: pets were not deleted")
+			return err
+		}
+		err = c.PetSets(cluster.Namespace).Delete(petSet.Name, nil)
+		if err != nil {
+			ErrorLog("spawner_petset: DeletePetSet: delete pet set error")
+			return err
+		}
+		DebugLog("spawner_petset: deleted pet set: "+petSet.Name)
+	}
+
+	kc, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("spawner_petset: DeletePetSet: Cannot connect to Kubernetes api ")
+		return err
+	}
+	// headless service
+	err = kc.Services(cluster.Namespace).Delete(cluster.PetSetServiceName())
+	if err != nil && !apierrors.IsNotFound(err) {
+		ErrorLog("spawner_petset: DeletePetSet: delete headless service error")
+		return err
+	}
+	// claims of pets, name is {template}-{petset}-{ordinal}
+	claimPrefix := CLUSTER_PREFIX + cluster.Tag + "-" + CLUSTER_PREFIX + cluster.Tag + "-"
+	pvcList, err := kc.PersistentVolumeClaims(cluster.Namespace).List(api.ListOptions{})
+	if err != nil {
+		ErrorLog("spawner_petset: DeletePetSet: list pvc error")
+		return err
+	}
+	for _, pvc := range pvcList.Items {
+		if !strings.HasPrefix(pvc.Name, claimPrefix) {
+			continue
+		}
+		// save-guard, rest of the name is ordinal
+		if _, err := strconv.Atoi(strings.TrimPrefix(pvc.Name, claimPrefix)); err != nil {
+			continue
+		}
+		err = kc.PersistentVolumeClaims(cluster.Namespace).Delete(pvc.Name)
+		if err != nil {
+			ErrorLog("spawner_petset: DeletePetSet: delete pvc error")
+			return err
+		}
+		DebugLog("spawner_petset: deleted pvc: "+pvc.Name)
+	}
+	return nil
+}
+
+// get pet set of cluster
+// @param cluster *CouchdbCluster - required: tag, namespace
+// @return *apps.PetSet
+// @return error
+func (cluster *CouchdbCluster) GetPetSet() (*apps.PetSet, error) {
+	c, err := KubeClientApps(KUBE_API)
+	if err != nil {
+		ErrorLog("spawner_petset: GetPetSet: Cannot connect to Kubernetes api ")
+		ErrorLog(err)
+		return nil, err
+	}
+	return c.PetSets(cluster.Namespace).Get(CLUSTER_PREFIX + cluster.Tag)
+}
+
+// spawner with pet set, each pet has own persistent volume claim created from claim template
+// claims are kept when pet set is scaled down, so pet gets its data back when scaled up again
+type PetSetSpawner struct{}
+
+func (s *PetSetSpawner) Create(cluster *CouchdbCluster) (error) {
+	// headless service has to exist before pets, it gives them dns names
+	svc, err := cluster.CreatePetSetService()
+	if err != nil {
+		ErrorLog("spawner_petset: Create: create headless service error")
+		return err
+	}
+	svcName, namespace := svc.Name, cluster.Namespace
+	cluster.Transaction.Track("service "+svcName, func() error {
+		c, err := KubeClient(KUBE_API)
+		if err != nil {
+			return err
+		}
+		return c.Services(namespace).Delete(svcName)
+	})
+	petSet, err := cluster.CreatePetSet()
+	if err != nil {
+		return err
+	}
+	// pet set is deleted together with claims of pets
+	cluster.Transaction.Track("pet set "+petSet.Name, cluster.DeletePetSet)
+	return nil
+}
+
+func (s *PetSetSpawner) Delete(cluster *CouchdbCluster) (error) {
+	return cluster.DeletePetSet()
+}
+
+func (s *PetSetSpawner) Scale(cluster *CouchdbCluster) (error) {
+	petSet, err := cluster.GetPetSet()
+	if err != nil {
+		return err
+	}
+	c, err := KubeClientApps(KUBE_API)
+	if err != nil {
+		ErrorLog("spawner_petset: Scale: Cannot connect to Kubernetes api ")
+		return err
+	}
+	petSet.Spec.Replicas = int(cluster.Replicas)
+	_, err = c.PetSets(cluster.Namespace).Update(petSet)
+	return err
+}
+
+func (s *PetSetSpawner) CurrentReplicas(cluster *CouchdbCluster) (int32, error) {
+	// cached pet set
+	if petSet, ok := cluster.cache().PetSet(cluster.Namespace, CLUSTER_PREFIX+cluster.Tag); ok && petSet != nil {
+		return int32(petSet.Spec.Replicas), nil
+	}
+	petSet, err := cluster.GetPetSet()
+	if err != nil {
+		return 0, err
+	}
+	return int32(petSet.Spec.Replicas), nil
+}
+
+// stable dns names of pets, they do not change when pet is recreated
+func (s *PetSetSpawner) PeerEndpoints(cluster *CouchdbCluster) ([]string, error) {
+	replicas, err := s.CurrentReplicas(cluster)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make([]string, replicas)
+	for i := range endpoints {
+		endpoints[i] = cluster.PetHostname(i)
+	}
+	return endpoints, nil
+}
@@ -1,12 +1,12 @@
 package kanto
 
 import (
+	"sort"
 	"strconv"
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/labels"
 	"strings"
 	"errors"
-	"k8s.io/kubernetes/pkg/api/resource"
 )
 
 // init replication controller struct and fill it with specs
@@ -34,6 +34,11 @@ func (cluster *CouchdbCluster) CouchdbReplicationController() (*api.ReplicationC
 		// OK
 		DebugLog("spawner_rc: created pvc "+pvClaim.Name)
 	}
+	// delete claim when cluster creation fails
+	pvcName, namespace := pvClaim.Name, cluster.Namespace
+	cluster.Transaction.Track("pvc "+pvcName, func() error {
+		return c.PersistentVolumeClaims(namespace).Delete(pvcName)
+	})
 
 	// get pod template for replication controller
 	podTemplate := cluster.CouchdbPodTemplate(true, pvClaim.Name)
@@ -68,6 +73,9 @@ func (cluster *CouchdbCluster) CreateReplicationControllers() (error) {
 			cluster.Labels[LABEL_REPLICA] = strconv.Itoa(i)
 			// init replication controller
 			rc, err := cluster.CouchdbReplicationController()
+			if err != nil {
+				return err
+			}
 			// create replication controller
 			rc, err = c.ReplicationControllers(cluster.Namespace).Create(rc)
 			if err != nil {
@@ -77,6 +85,10 @@ func (cluster *CouchdbCluster) CreateReplicationControllers() (error) {
 			} else {
 				DebugLog("spawner_rc: created replication controller: "+rc.Name)
 			}
+			rcName, namespace := rc.Name, cluster.Namespace
+			cluster.Transaction.Track("replication controller "+rcName, func() error {
+				return c.ReplicationControllers(namespace).Delete(rcName)
+			})
 			// create service for pod
 			svc, err := cluster.CreatePodService(cluster.Labels)
 			if err != nil {
@@ -86,6 +98,10 @@ func (cluster *CouchdbCluster) CreateReplicationControllers() (error) {
 			} else {
 				DebugLog("spawner_rc: created pod service: "+svc.Name)
 			}
+			svcName := svc.Name
+			cluster.Transaction.Track("pod service "+svcName, func() error {
+				return c.Services(namespace).Delete(svcName)
+			})
 
 		}
 	}
@@ -158,6 +174,11 @@ func (cluster *CouchdbCluster) DeleteReplicationControllers() (error) {
 			}
 		}
 	}
+	// replication controllers could still spawn pods, wait until they are gone
+	err = cluster.WaitForReplicationControllersDeleted(DELETE_TIMEOUT)
+	if err != nil {
+		return err
+	}
 	// delete all pvc
 	pvcList, err := c.PersistentVolumeClaims(cluster.Namespace).List(listOptions)
 	if err != nil {
@@ -210,7 +231,7 @@ func (cluster *CouchdbCluster) DeleteReplicationControllers() (error) {
 	return nil
 }
 
-// scale couchdb cluster to new replica number
+// scale replication controllers to new replica number
 // @param cluster *CouchdbCluster - coucbdb cluster with new replica number
 // @param rcList *[]api.ReplicationController - lsit of current rc - fetched via GetReplicationCOntrollers()
 // @return error - error
@@ -231,23 +252,13 @@ func (cluster *CouchdbCluster) ScaleRC(rcList *[]api.ReplicationController) (err
 		// scale down
 		DebugLog("spawner_rc: scaleRC: Scaling Down")
 		err = cluster.ScaleRCDown(newReplicas, currentReplicas)
-	} else {
-		// newReplicas == currentReplicas
-		//  nothing to do
-		return nil
 	}
+	// newReplicas == currentReplicas, nothing to do
 	// check for errors
 	if err != nil {
 		ErrorLog("spawner_rc: ScaleRC: scale error")
 		return err
 	}
-
-	// we need to reconfigure replication
-	err = cluster.SetupReplication(DatabasesToReplicate(cluster.Username))
-	if err != nil {
-		ErrorLog("spawner_rc : ScaleRC: reconfigure replication error")
-		return err
-	}
 	//everything OK
 	return nil
 }
@@ -377,22 +388,13 @@ func (cluster *CouchdbCluster) ScaleRCup(newReplicas int, currentReplicas int) (
 }
 
 // create pvc claim for couchdb pod, used for rc spawner
-// pvc name is automatically generated by kubernetes
+// pvc name is automatically generated by kubernetes, size and storage class are taken from cluster storage
 // @param cluster *CouchdbCluster - cluster that will be using this pvc
 // @return *api.PersistentVolumeClaim - filled pvc claim struct ready to be created
 func (cluster *CouchdbCluster) CouchdbPVClaim() (*api.PersistentVolumeClaim){
-	// resource list
-	rsList := make(api.ResourceList)
-	// SIZE
-	rsList[api.ResourceStorage] = *(resource.NewQuantity(COUCHDB_VOLUME_SIZE, resource.BinarySI))
-
-	// pvc SPEC, witch readWriteOnce access mode
-	pvcSpec := api.PersistentVolumeClaimSpec{AccessModes: []api.PersistentVolumeAccessMode{api.ReadWriteOnce}}
-	pvcSpec.Resources.Requests = api.ResourceList(rsList)
 	// PVC
-	pvc := api.PersistentVolumeClaim{Spec:pvcSpec}
+	pvc := cluster.volumeClaim()
 	pvc.GenerateName = CLUSTER_PREFIX + cluster.Tag + "-"
-	pvc.Labels = cluster.Labels
 
 	return &pvc
 }
@@ -460,6 +462,10 @@ func (cluster *CouchdbCluster) DeletePodService(selector map[string]string) (err
 
 // get all pod services
 func (cluster *CouchdbCluster)GetAllPodServices() (*[]api.Service, error) {
+	// cached pod services
+	if services, ok := cluster.cache().PodServices(cluster); ok && len(services) > 0 {
+		return &services, nil
+	}
 	// service special label
 	serviceLabels := make(map[string]string)
 	for k,v := range cluster.Labels {
@@ -489,4 +495,231 @@ func (cluster *CouchdbCluster)GetAllPodServices() (*[]api.Service, error) {
 		// fine, no errors
 		return &svcList.Items, nil
 	}
-}
\ No newline at end of file
+}
+
+// spawner with replication controller, persistent volume claim and pod service for each replica
+// replication is configured via pod services, their ip does not change when pod is recreated
+type RCSpawner struct{}
+
+func (s *RCSpawner) Create(cluster *CouchdbCluster) (error) {
+	err := cluster.CreateReplicationControllers()
+	// clear replica labels
+	delete(cluster.Labels, LABEL_REPLICA)
+	return err
+}
+
+func (s *RCSpawner) Delete(cluster *CouchdbCluster) (error) {
+	return cluster.DeleteReplicationControllers()
+}
+
+func (s *RCSpawner) Scale(cluster *CouchdbCluster) (error) {
+	rcList, err := cluster.GetReplicationControllers()
+	if err != nil {
+		return err
+	}
+	return cluster.ScaleRC(rcList)
+}
+
+func (s *RCSpawner) CurrentReplicas(cluster *CouchdbCluster) (int32, error) {
+	// cached replication controllers, empty cache can mean just created cluster
+	if rcs, ok := cluster.cache().ReplicationControllers(cluster); ok && len(rcs) > 0 {
+		return int32(len(rcs)), nil
+	}
+	rcList, err := cluster.GetReplicationControllers()
+	if err != nil {
+		return 0, err
+	}
+	// each replication controller means one replica for couchdb cluster
+	return int32(len(*rcList)), nil
+}
+
+// cluster ips of pod services, ordered by replica index
+func (s *RCSpawner) PeerEndpoints(cluster *CouchdbCluster) ([]string, error) {
+	podSvcList, err := cluster.GetAllPodServices()
+	if err != nil {
+		return nil, err
+	}
+	podSvcs := *podSvcList
+	sort.Sort(servicesByReplica(podSvcs))
+	endpoints := make([]string, len(podSvcs))
+	for i, svc := range podSvcs {
+		endpoints[i] = svc.Spec.ClusterIP
+	}
+	return endpoints, nil
+}
+
+// update pod template of all replication controllers, running pods are kept
+func (s *RCSpawner) UpdatePodTemplate(cluster *CouchdbCluster, update func(*api.PodTemplateSpec) bool) (bool, error) {
+	rcList, err := cluster.GetReplicationControllers()
+	if err != nil {
+		return false, err
+	}
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("spawner_rc: UpdatePodTemplate: Cannot connect to Kubernetes api ")
+		return false, err
+	}
+	for i := range *rcList {
+		rc := &(*rcList)[i]
+		if !update(rc.Spec.Template) {
+			continue
+		}
+		_, err = c.ReplicationControllers(cluster.Namespace).Update(rc)
+		if err != nil {
+			ErrorLog("spawner_rc: UpdatePodTemplate: update repl controller error: "+rc.Name)
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// move replica to new claim, replication controller is scaled to 0, its pod template is switched to new claim
+// and it is scaled back to 1, old claim is kept
+// if new claim cannot be used, replication controller is started again with old claim
+func (s *RCSpawner) ReplaceVolumeClaim(cluster *CouchdbCluster, claim *api.PersistentVolumeClaim) (string, error) {
+	replica, ok := claim.Labels[LABEL_REPLICA]
+	if !ok {
+		return "", errors.New("claim "+claim.Name+" does not belong to any replica")
+	}
+	replicaCluster := cluster.ReplicaCluster(replica)
+
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("spawner_rc: ReplaceVolumeClaim: Cannot connect to Kubernetes api ")
+		return "", err
+	}
+	rcList, err := replicaCluster.GetReplicationControllers()
+	if err != nil {
+		return "", err
+	}
+	if len(*rcList) != 1 {
+		return "", errors.New("replica "+replica+" has "+strconv.Itoa(len(*rcList))+" replication controllers")
+	}
+	rc := &(*rcList)[0]
+
+	// stop pod, claim can be mounted only by one pod
+	rc.Spec.Replicas = 0
+	updated, err := c.ReplicationControllers(cluster.Namespace).Update(rc)
+	if err != nil {
+		ErrorLog("spawner_rc: ReplaceVolumeClaim: scale down repl controller error: "+rc.Name)
+		return "", err
+	}
+	rc = updated
+	err = replicaCluster.WaitForPodsDeleted(DELETE_TIMEOUT)
+	if err != nil {
+		return "", err
+	}
+
+	// new empty claim with size from cluster storage
+	newClaim, err := c.PersistentVolumeClaims(cluster.Namespace).Create(replicaCluster.CouchdbPVClaim())
+	if err != nil {
+		ErrorLog("spawner_rc: ReplaceVolumeClaim: failed create pvc")
+		ErrorLog(err)
+	} else {
+		DebugLog("spawner_rc: created pvc "+newClaim.Name+" for replica "+replica)
+		for i := range rc.Spec.Template.Spec.Volumes {
+			volume := &rc.Spec.Template.Spec.Volumes[i]
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == claim.Name {
+				volume.PersistentVolumeClaim.ClaimName = newClaim.Name
+			}
+		}
+	}
+
+	// start pod again, with old claim if new claim was not created
+	rc.Spec.Replicas = 1
+	_, updateErr := c.ReplicationControllers(cluster.Namespace).Update(rc)
+	if updateErr != nil {
+		ErrorLog("spawner_rc: ReplaceVolumeClaim: scale up repl controller error: "+rc.Name)
+		return "", updateErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return cluster.ReplicaEndpoint(replica)
+}
+
+// replace pods of replication controllers one replica at a time, replication controller creates new pod from its template
+// replica keeps its data on volume, next replica is touched only after new pod is ready
+// and has documents written to peers while it was down
+func (s *RCSpawner) RollPods(cluster *CouchdbCluster) error {
+	rcList, err := cluster.GetReplicationControllers()
+	if err != nil {
+		return err
+	}
+	var databases []string
+	if len(*rcList) > 1 {
+		databases, err = cluster.DatabasesToReplicate()
+		if err != nil {
+			return err
+		}
+	}
+	for _, rc := range *rcList {
+		replica := rc.Labels[LABEL_REPLICA]
+		replicaCluster := cluster.ReplicaCluster(replica)
+		replicaCluster.Replicas = 1
+		err = replicaCluster.DeletePods()
+		if err != nil {
+			ErrorLog("spawner_rc: RollPods: delete pod error, replica: "+replica)
+			return err
+		}
+		err = replicaCluster.WaitForPodsReady(POD_READY_TIMEOUT)
+		if err != nil {
+			return err
+		}
+		if len(*rcList) < 2 {
+			continue
+		}
+		peer, err := cluster.ReplicaEndpoint(replica)
+		if err != nil {
+			return err
+		}
+		err = cluster.WaitForPeerSeeded(peer, databases, POD_READY_TIMEOUT)
+		if err != nil {
+			return err
+		}
+		InfoLog("spawner_rc: replica "+replica+" of cluster_tag: "+cluster.Tag+" replaced")
+	}
+	return nil
+}
+
+// cluster struct limited to components of one replica
+// @param replica string - value of replica label
+// @return *CouchdbCluster - copy of cluster with own labels
+func (cluster *CouchdbCluster) ReplicaCluster(replica string) *CouchdbCluster {
+	replicaCluster := *cluster
+	replicaCluster.Labels = make(map[string]string)
+	for k, v := range cluster.Labels {
+		replicaCluster.Labels[k] = v
+	}
+	replicaCluster.Labels[LABEL_REPLICA] = replica
+	return &replicaCluster
+}
+
+// peer endpoint of replica, ip of its pod service
+// @param replica string - value of replica label
+// @return string
+// @return error
+func (cluster *CouchdbCluster) ReplicaEndpoint(replica string) (string, error) {
+	podSvcList, err := cluster.GetAllPodServices()
+	if err != nil {
+		return "", err
+	}
+	for _, svc := range *podSvcList {
+		if svc.Labels[LABEL_REPLICA] == replica {
+			return svc.Spec.ClusterIP, nil
+		}
+	}
+	return "", errors.New("pod service of replica "+replica+" not found")
+}
+
+// sort pod services by replica label
+type servicesByReplica []api.Service
+
+func (s servicesByReplica) Len() int      { return len(s) }
+func (s servicesByReplica) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s servicesByReplica) Less(i, j int) bool {
+	a, _ := strconv.Atoi(s[i].Labels[LABEL_REPLICA])
+	b, _ := strconv.Atoi(s[j].Labels[LABEL_REPLICA])
+	return a < b
+}
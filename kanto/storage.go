@@ -0,0 +1,149 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for persistent storage of couchdb pods
+// size and storage class of volume claims are chosen by user on create, bounded by operator configured limits
+// and saved in cluster service annotation, so claims of pods added by scaling are same
+package kanto
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
+)
+
+const (
+	// annotation of cluster service with storage of cluster
+	ANNOTATION_STORAGE = "kanto/storage"
+	// annotation of claim with requested storage class
+	ANNOTATION_STORAGE_CLASS = "volume.beta.kubernetes.io/storage-class"
+)
+
+// storage of couchdb pods, used only by spawners with persistent volumes (rc, petset)
+type ClusterStorage struct {
+	// size of volume claim of each pod
+	Size *resource.Quantity `json:",omitempty"`
+	// storage class of volume claims, empty means default class
+	Class string `json:",omitempty"`
+}
+
+// min and max size of volume claim, set in main.go from os env, nil means not bounded
+var STORAGE_MIN_SIZE *resource.Quantity
+var STORAGE_MAX_SIZE *resource.Quantity
+
+// storage classes users can choose, set in main.go from os env
+// default class (empty) is always allowed
+var STORAGE_CLASSES = []string{}
+
+// storage of clusters created without storage, set in main.go from os env
+var DEFAULT_STORAGE = ClusterStorage{Size: resource.NewQuantity(COUCHDB_VOLUME_SIZE, resource.BinarySI)}
+
+// parse storage from v0 form values storage_size, storage_class
+// @param r *http.Request
+// @return *ClusterStorage - nil if no value is set
+// @return error - invalid size
+func ParseStorageForm(r *http.Request) (*ClusterStorage, error) {
+	size, class := r.FormValue("storage_size"), r.FormValue("storage_class")
+	if size == "" && class == "" {
+		return nil, nil
+	}
+	storage := &ClusterStorage{Class: class}
+	if size != "" {
+		quantity, err := resource.ParseQuantity(size)
+		if err != nil {
+			ErrorLog("storage: ParseStorageForm: invalid storage size: " + size)
+			return nil, err
+		}
+		storage.Size = &quantity
+	}
+	return storage, nil
+}
+
+// merge storage, values set in changes replace values from base
+// @param base *ClusterStorage - can be nil
+// @param changes *ClusterStorage - can be nil
+// @return *ClusterStorage - new struct, base and changes are not modified
+func MergeStorage(base *ClusterStorage, changes *ClusterStorage) *ClusterStorage {
+	merged := &ClusterStorage{}
+	for _, storage := range []*ClusterStorage{base, changes} {
+		if storage == nil {
+			continue
+		}
+		if storage.Size != nil {
+			merged.Size = storage.Size.Copy()
+		}
+		if storage.Class != "" {
+			merged.Class = storage.Class
+		}
+	}
+	return merged
+}
+
+// check that size is set and within STORAGE_MIN_SIZE and STORAGE_MAX_SIZE and class is allowed
+// @param storage *ClusterStorage
+// @return error - message for user
+func ValidateStorage(storage *ClusterStorage) error {
+	if storage.Size == nil {
+		return errors.New("storage size is not set")
+	}
+	if STORAGE_MIN_SIZE != nil && storage.Size.Cmp(*STORAGE_MIN_SIZE) < 0 {
+		return errors.New("storage size " + storage.Size.String() + " is lower than minimum " + STORAGE_MIN_SIZE.String())
+	}
+	if STORAGE_MAX_SIZE != nil && storage.Size.Cmp(*STORAGE_MAX_SIZE) > 0 {
+		return errors.New("storage size " + storage.Size.String() + " is higher than maximum " + STORAGE_MAX_SIZE.String())
+	}
+	if storage.Class == "" {
+		return nil
+	}
+	for _, class := range STORAGE_CLASSES {
+		if class == storage.Class {
+			return nil
+		}
+	}
+	return errors.New("storage class " + storage.Class + " is not allowed")
+}
+
+// storage saved in cluster service annotation
+// @param svc *api.Service - cluster service, can be nil
+// @return *ClusterStorage - nil if service has no (valid) annotation
+func StorageOf(svc *api.Service) *ClusterStorage {
+	if svc == nil || svc.Annotations[ANNOTATION_STORAGE] == "" {
+		return nil
+	}
+	storage := &ClusterStorage{}
+	if err := json.Unmarshal([]byte(svc.Annotations[ANNOTATION_STORAGE]), storage); err != nil {
+		ErrorLog("storage: StorageOf: invalid annotation of service " + svc.Name)
+		ErrorLog(err)
+		return nil
+	}
+	return storage
+}
+
+// annotation value with cluster storage
+// @return string - empty if cluster has no storage
+func (cluster *CouchdbCluster) storageAnnotation() string {
+	if cluster.Storage == nil {
+		return ""
+	}
+	value, _ := json.Marshal(cluster.Storage)
+	return string(value)
+}
+
+// volume claim for cluster pod, size and class are taken from cluster.Storage,
+// clusters without storage (created before storage was configurable) use DEFAULT_STORAGE
+// @return api.PersistentVolumeClaim - claim without name
+func (cluster *CouchdbCluster) volumeClaim() api.PersistentVolumeClaim {
+	storage := MergeStorage(&DEFAULT_STORAGE, cluster.Storage)
+	// pvc SPEC, witch readWriteOnce access mode
+	pvcSpec := api.PersistentVolumeClaimSpec{AccessModes: []api.PersistentVolumeAccessMode{api.ReadWriteOnce}}
+	pvcSpec.Resources.Requests = api.ResourceList{api.ResourceStorage: *storage.Size}
+	pvc := api.PersistentVolumeClaim{Spec: pvcSpec}
+	pvc.Labels = cluster.Labels
+	if storage.Class != "" {
+		pvc.Annotations = map[string]string{ANNOTATION_STORAGE_CLASS: storage.Class}
+	}
+	return pvc
+}
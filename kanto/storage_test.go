@@ -0,0 +1,83 @@
+package kanto
+
+import (
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api/resource"
+)
+
+func quantity(value string) *resource.Quantity {
+	q := resource.MustParse(value)
+	return &q
+}
+
+func TestValidateStorage(t *testing.T) {
+	defer func(min *resource.Quantity, max *resource.Quantity, classes []string) {
+		STORAGE_MIN_SIZE, STORAGE_MAX_SIZE, STORAGE_CLASSES = min, max, classes
+	}(STORAGE_MIN_SIZE, STORAGE_MAX_SIZE, STORAGE_CLASSES)
+	STORAGE_MIN_SIZE = quantity("1Gi")
+	STORAGE_MAX_SIZE = quantity("100Gi")
+	STORAGE_CLASSES = []string{"ssd"}
+
+	tests := []struct {
+		name    string
+		storage ClusterStorage
+		valid   bool
+	}{
+		{"size not set", ClusterStorage{}, false},
+		{"default class", ClusterStorage{Size: quantity("10Gi")}, true},
+		{"allowed class", ClusterStorage{Size: quantity("10Gi"), Class: "ssd"}, true},
+		{"unknown class", ClusterStorage{Size: quantity("10Gi"), Class: "hdd"}, false},
+		{"at minimum", ClusterStorage{Size: quantity("1Gi")}, true},
+		{"below minimum", ClusterStorage{Size: quantity("512Mi")}, false},
+		{"at maximum", ClusterStorage{Size: quantity("100Gi")}, true},
+		{"above maximum", ClusterStorage{Size: quantity("1Ti")}, false},
+	}
+	for _, test := range tests {
+		err := ValidateStorage(&test.storage)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: ValidateStorage() error = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+
+	// without bounds every size is valid
+	STORAGE_MIN_SIZE, STORAGE_MAX_SIZE = nil, nil
+	if err := ValidateStorage(&ClusterStorage{Size: quantity("1Ti")}); err != nil {
+		t.Errorf("ValidateStorage() without bounds error = %v", err)
+	}
+}
+
+func TestMergeStorage(t *testing.T) {
+	base := &ClusterStorage{Size: quantity("10Gi"), Class: "ssd"}
+	tests := []struct {
+		name    string
+		base    *ClusterStorage
+		changes *ClusterStorage
+		size    string
+		class   string
+	}{
+		{"both nil", nil, nil, "", ""},
+		{"no changes", base, nil, "10Gi", "ssd"},
+		{"no base", nil, base, "10Gi", "ssd"},
+		{"size change keeps class", base, &ClusterStorage{Size: quantity("20Gi")}, "20Gi", "ssd"},
+		{"class change keeps size", base, &ClusterStorage{Class: "hdd"}, "10Gi", "hdd"},
+	}
+	for _, test := range tests {
+		merged := MergeStorage(test.base, test.changes)
+		if test.size == "" && merged.Size != nil {
+			t.Errorf("%s: size = %s, want not set", test.name, merged.Size.String())
+		} else if test.size != "" && (merged.Size == nil || merged.Size.Cmp(resource.MustParse(test.size)) != 0) {
+			t.Errorf("%s: size = %v, want %s", test.name, merged.Size, test.size)
+		}
+		if merged.Class != test.class {
+			t.Errorf("%s: class = %q, want %q", test.name, merged.Class, test.class)
+		}
+	}
+
+	// merged size is a copy, base is not modified
+	merged := MergeStorage(base, nil)
+	merged.Size.Add(resource.MustParse("1Gi"))
+	if base.Size.Cmp(resource.MustParse("10Gi")) != 0 {
+		t.Errorf("MergeStorage() shares size with base, base size = %s", base.Size.String())
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"math/rand"
 	"time"
 	"log"
+	"strings"
 )
 
 // constants
@@ -33,6 +34,26 @@ func RandStringName(n int) string {
     return string(b)
 }
 
+// check if cluster tag can be used as part of kubernetes component names
+// tag has to be MIN_CLUSTER_TAG - MAX_CLUSTER_TAG characters long,
+// contain only lowercase letters, numbers and "-" and cannot start or end with "-"
+// @param tag string - cluster tag
+// @return bool - true if tag is valid
+func ValidClusterTag(tag string) bool {
+	if len(tag) < MIN_CLUSTER_TAG || len(tag) > MAX_CLUSTER_TAG {
+		return false
+	}
+	if strings.HasPrefix(tag, "-") || strings.HasSuffix(tag, "-") {
+		return false
+	}
+	for _, char := range tag {
+		if !strings.ContainsRune(string(letterRunes), char) && char != '-' {
+			return false
+		}
+	}
+	return true
+}
+
 // return couchdb cluster endpoint URL
 // @param clusterIp - cluster ip from kubernetes service
 func ClusterEndpoint(clusterIp string) (string) {
@@ -0,0 +1,254 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for tenant namespaces
+// kanto can keep all clusters in one shared namespace (separated only by "user" label)
+// or create namespace for each user or team, with resource quota and default container limits
+package kanto
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/resource"
+)
+
+const (
+	// all clusters in namespace "default"
+	NAMESPACE_MODE_SHARED = "shared"
+	// namespace for each user
+	NAMESPACE_MODE_USER = "user"
+	// namespace for each team, users are assigned to teams in teams file
+	NAMESPACE_MODE_TEAM = "team"
+
+	// prefixes of tenant namespaces, user "dev" and team "dev" get different namespaces
+	NAMESPACE_PREFIX_USER = "kanto-u-"
+	NAMESPACE_PREFIX_TEAM = "kanto-t-"
+	// max length of kubernetes namespace name
+	MAX_NAMESPACE_LENGTH = 63
+	// length of hash appended to tenant names that had to be rewritten or truncated
+	NAMESPACE_HASH_LENGTH = 8
+
+	LABEL_TENANT = "kanto_tenant"
+	// annotation of tenant namespace with owner tenant ("user:{username}" or "team:{team}")
+	ANNOTATION_TENANT = "kanto/tenant"
+
+	TENANT_QUOTA_NAME  = "kanto-quota"
+	TENANT_LIMITS_NAME = "kanto-limits"
+)
+
+// tenant configuration, set in main.go from os env
+var TENANTS = &TenantConfig{Mode: NAMESPACE_MODE_SHARED}
+
+// default path to teams file, can be overwritten by os ENV "NAMESPACE_TEAMS_FILE"
+var NAMESPACE_TEAMS_FILE string = "./kanto_teams"
+
+// tenant namespace configuration
+type TenantConfig struct {
+	// one of NAMESPACE_MODE_SHARED, NAMESPACE_MODE_USER, NAMESPACE_MODE_TEAM
+	Mode string
+	// team of each user, used in team mode
+	Teams map[string]string
+	// hard limits of resource quota created in each tenant namespace, empty means no quota
+	Quota api.ResourceList
+	// default container limits and requests of limit range created in each tenant namespace
+	// pods have to have limits when quota limits cpu or memory
+	DefaultLimits   api.ResourceList
+	DefaultRequests api.ResourceList
+}
+
+// get tenant of user, namespace of user clusters is derived from it
+// users without team have own user tenant in team mode
+// @param username string
+// @return string - tenant kind, NAMESPACE_MODE_USER or NAMESPACE_MODE_TEAM, empty in shared mode
+// @return string - tenant name
+func (config *TenantConfig) Tenant(username string) (string, string) {
+	switch config.Mode {
+	case NAMESPACE_MODE_USER:
+		return NAMESPACE_MODE_USER, username
+	case NAMESPACE_MODE_TEAM:
+		if team, ok := config.Teams[username]; ok {
+			return NAMESPACE_MODE_TEAM, team
+		}
+		return NAMESPACE_MODE_USER, username
+	}
+	return "", ""
+}
+
+// get namespace for user clusters
+// @param username string
+// @return string - namespace name
+func (config *TenantConfig) Namespace(username string) string {
+	kind, tenant := config.Tenant(username)
+	if kind == "" {
+		return api.NamespaceDefault
+	}
+	return NamespaceName(kind, tenant)
+}
+
+// create tenant namespace with resource quota and limit range, if it does not exist yet
+// nothing is done in shared mode
+// @param username string - user for which namespace is created
+// @return string - namespace name
+// @return error
+func (config *TenantConfig) EnsureNamespace(username string) (string, error) {
+	namespace := config.Namespace(username)
+	if config.Mode != NAMESPACE_MODE_USER && config.Mode != NAMESPACE_MODE_TEAM {
+		return namespace, nil
+	}
+	// get kube client
+	c, err := KubeClient(KUBE_API)
+	if err != nil {
+		ErrorLog("tenant: EnsureNamespace: Cannot connect to Kubernetes api ")
+		ErrorLog(err)
+		return namespace, err
+	}
+	labels := map[string]string{LABEL_TENANT: namespace}
+	kind, tenant := config.Tenant(username)
+	owner := kind + ":" + tenant
+
+	// namespace, owner annotation detects tenants whose names map to same namespace
+	ns := &api.Namespace{}
+	ns.Name = namespace
+	ns.Labels = labels
+	ns.Annotations = map[string]string{ANNOTATION_TENANT: owner}
+	_, err = c.Namespaces().Create(ns)
+	if apierrors.IsAlreadyExists(err) {
+		existing, err := c.Namespaces().Get(namespace)
+		if err != nil {
+			ErrorLog("tenant: EnsureNamespace: get namespace error: " + namespace)
+			ErrorLog(err)
+			return namespace, err
+		}
+		if existing.Annotations[ANNOTATION_TENANT] != owner {
+			ErrorLog("tenant: EnsureNamespace: namespace " + namespace + " belongs to other tenant, requested by " + owner)
+			return namespace, errors.New("namespace " + namespace + " belongs to other tenant")
+		}
+	} else if err != nil {
+		ErrorLog("tenant: EnsureNamespace: create namespace error: " + namespace)
+		ErrorLog(err)
+		return namespace, err
+	} else {
+		InfoLog("tenant: created namespace " + namespace)
+	}
+
+	// resource quota
+	if len(config.Quota) > 0 {
+		quota := &api.ResourceQuota{Spec: api.ResourceQuotaSpec{Hard: config.Quota}}
+		quota.Name = TENANT_QUOTA_NAME
+		quota.Labels = labels
+		_, err = c.ResourceQuotas(namespace).Create(quota)
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			ErrorLog("tenant: EnsureNamespace: create resource quota error: " + namespace)
+			ErrorLog(err)
+			return namespace, err
+		}
+	}
+
+	// default container limits
+	if len(config.DefaultLimits) > 0 || len(config.DefaultRequests) > 0 {
+		limitItem := api.LimitRangeItem{Type: api.LimitTypeContainer, Default: config.DefaultLimits,
+			DefaultRequest: config.DefaultRequests}
+		limitRange := &api.LimitRange{Spec: api.LimitRangeSpec{Limits: []api.LimitRangeItem{limitItem}}}
+		limitRange.Name = TENANT_LIMITS_NAME
+		limitRange.Labels = labels
+		_, err = c.LimitRanges(namespace).Create(limitRange)
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			ErrorLog("tenant: EnsureNamespace: create limit range error: " + namespace)
+			ErrorLog(err)
+			return namespace, err
+		}
+	}
+	return namespace, nil
+}
+
+// convert tenant name to valid kubernetes namespace name (lowercase letters, numbers and "-")
+// name that had to be rewritten or truncated gets hash of original name, so "a.b" and "a_b" get different namespaces
+// @param kind string - NAMESPACE_MODE_USER or NAMESPACE_MODE_TEAM
+// @param tenant string
+// @return string - namespace name with NAMESPACE_PREFIX_USER or NAMESPACE_PREFIX_TEAM
+func NamespaceName(kind string, tenant string) string {
+	prefix := NAMESPACE_PREFIX_USER
+	if kind == NAMESPACE_MODE_TEAM {
+		prefix = NAMESPACE_PREFIX_TEAM
+	}
+	name := []rune{}
+	for _, r := range strings.ToLower(tenant) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			name = append(name, r)
+		} else {
+			name = append(name, '-')
+		}
+	}
+	clean := strings.Trim(string(name), "-")
+	maxLength := MAX_NAMESPACE_LENGTH - len(prefix)
+	if clean == tenant && len(clean) <= maxLength {
+		return prefix + clean
+	}
+
+	sum := sha256.Sum256([]byte(tenant))
+	hash := hex.EncodeToString(sum[:])[:NAMESPACE_HASH_LENGTH]
+	maxLength -= NAMESPACE_HASH_LENGTH + 1
+	if len(clean) > maxLength {
+		clean = strings.TrimRight(clean[:maxLength], "-")
+	}
+	if clean == "" {
+		return prefix + hash
+	}
+	return prefix + clean + "-" + hash
+}
+
+// parse resource quantities, empty values are skipped
+// @param values map[api.ResourceName]string - ie. "cpu": "500m", "memory": "1Gi"
+// @return api.ResourceList
+// @return error - invalid quantity
+func ParseResourceList(values map[api.ResourceName]string) (api.ResourceList, error) {
+	list := make(api.ResourceList)
+	for name, value := range values {
+		if value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			ErrorLog("tenant: ParseResourceList: invalid quantity for " + string(name) + ": " + value)
+			return nil, err
+		}
+		list[name] = quantity
+	}
+	return list, nil
+}
+
+// load teams file, each line is "username:team", lines starting with "#" are ignored
+// @param path string
+// @return map[string]string - team of each user
+// @return error
+func LoadTeams(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		ErrorLog("tenant: LoadTeams: cannot open teams file " + path)
+		return nil, err
+	}
+	defer file.Close()
+
+	teams := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			DebugLog("tenant: LoadTeams: skipping invalid line in teams file")
+			continue
+		}
+		teams[parts[0]] = parts[1]
+	}
+	return teams, scanner.Err()
+}
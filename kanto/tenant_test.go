@@ -0,0 +1,67 @@
+package kanto
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNamespaceName(t *testing.T) {
+	long := strings.Repeat("a", 70)
+	tests := []struct {
+		kind   string
+		tenant string
+		want   string
+		// namespace has hash suffix instead of exact name
+		hashed bool
+	}{
+		{NAMESPACE_MODE_USER, "dev", "kanto-u-dev", false},
+		{NAMESPACE_MODE_TEAM, "dev", "kanto-t-dev", false},
+		{NAMESPACE_MODE_USER, "team-1", "kanto-u-team-1", false},
+		{NAMESPACE_MODE_USER, "Dev", "kanto-u-dev-", true},
+		{NAMESPACE_MODE_USER, "a.b", "kanto-u-a-b-", true},
+		{NAMESPACE_MODE_USER, "a_b", "kanto-u-a-b-", true},
+		{NAMESPACE_MODE_USER, "-dev-", "kanto-u-dev-", true},
+		{NAMESPACE_MODE_USER, "...", "kanto-u-", true},
+		{NAMESPACE_MODE_TEAM, long, "kanto-t-aaaa", true},
+	}
+	for _, test := range tests {
+		name := NamespaceName(test.kind, test.tenant)
+		if len(name) > MAX_NAMESPACE_LENGTH {
+			t.Errorf("NamespaceName(%s, %q) = %s is longer than %d", test.kind, test.tenant, name, MAX_NAMESPACE_LENGTH)
+		}
+		if !test.hashed && name != test.want {
+			t.Errorf("NamespaceName(%s, %q) = %s, want %s", test.kind, test.tenant, name, test.want)
+		}
+		if test.hashed && (!strings.HasPrefix(name, test.want) || len(name) < len(test.want)+NAMESPACE_HASH_LENGTH) {
+			t.Errorf("NamespaceName(%s, %q) = %s, want %s with hash", test.kind, test.tenant, name, test.want)
+		}
+		if strings.Contains(name, "--") || strings.HasSuffix(name, "-") {
+			t.Errorf("NamespaceName(%s, %q) = %s is not valid namespace", test.kind, test.tenant, name)
+		}
+		// same tenant always gets same namespace
+		if again := NamespaceName(test.kind, test.tenant); again != name {
+			t.Errorf("NamespaceName(%s, %q) changed from %s to %s", test.kind, test.tenant, name, again)
+		}
+	}
+}
+
+func TestNamespaceNameCollisions(t *testing.T) {
+	tenants := [][2]string{
+		{NAMESPACE_MODE_USER, "a.b"},
+		{NAMESPACE_MODE_USER, "a_b"},
+		{NAMESPACE_MODE_USER, "a-b"},
+		{NAMESPACE_MODE_USER, "A-B"},
+		{NAMESPACE_MODE_TEAM, "a-b"},
+		{NAMESPACE_MODE_USER, strings.Repeat("a", 70)},
+		{NAMESPACE_MODE_USER, strings.Repeat("a", 71)},
+	}
+	seen := map[string]string{}
+	for _, tenant := range tenants {
+		name := NamespaceName(tenant[0], tenant[1])
+		key := tenant[0] + ":" + tenant[1]
+		if other, ok := seen[name]; ok {
+			t.Errorf("tenants %s and %s share namespace %s", other, key, name)
+		}
+		seen[name] = key
+	}
+}
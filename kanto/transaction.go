@@ -0,0 +1,103 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for cluster creation transactions
+// every kubernetes resource created during cluster creation is tracked with function that deletes it,
+// when creation fails, all tracked resources are deleted in reverse order
+package kanto
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+)
+
+// tracked resource with function that deletes it
+type rollbackAction struct {
+	name string
+	undo func() error
+}
+
+// resources created in one transaction
+type Transaction struct {
+	mutex   sync.Mutex
+	actions []rollbackAction
+}
+
+// error returned when transaction was rolled back
+// contains original error and result of rollback
+type RollbackError struct {
+	// error that caused rollback
+	Err error `json:"-"`
+	// Err message, for json output
+	Cause string `json:"cause"`
+	// deleted resources, in order of deletion
+	RolledBack []string `json:"rolled_back"`
+	// resources that could not be deleted and their errors, these have to be deleted manually
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// error message with original error and rollback result
+// failed resources are sorted by name, so same rollback always gives same message
+func (e *RollbackError) Error() string {
+	msg := e.Cause + "; rolled back: " + strings.Join(e.RolledBack, ", ")
+	if len(e.Failed) > 0 {
+		names := []string{}
+		for name := range e.Failed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		failed := []string{}
+		for _, name := range names {
+			failed = append(failed, name+" ("+e.Failed[name]+")")
+		}
+		msg += "; rollback failed: " + strings.Join(failed, ", ")
+	}
+	return msg
+}
+
+// track created resource
+// safe to call on nil transaction (cluster functions called outside of transaction)
+// @param name string - resource description, ie. "service cdb-clust-abcd"
+// @param undo func() error - deletes resource
+func (tx *Transaction) Track(name string, undo func() error) {
+	if tx == nil {
+		return
+	}
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+	tx.actions = append(tx.actions, rollbackAction{name: name, undo: undo})
+}
+
+// delete all tracked resources in reverse order
+// rollback continues when deletion of resource fails, resource which does not exist is considered deleted
+// @param cause error - error that caused rollback
+// @return *RollbackError - cause with rollback result
+func (tx *Transaction) Rollback(cause error) *RollbackError {
+	result := &RollbackError{Err: cause, Cause: cause.Error(), RolledBack: []string{}}
+	if tx == nil {
+		return result
+	}
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+
+	for i := len(tx.actions) - 1; i >= 0; i-- {
+		action := tx.actions[i]
+		err := action.undo()
+		if err != nil && !apierrors.IsNotFound(err) {
+			ErrorLog("transaction: rollback of " + action.name + " failed")
+			ErrorLog(err)
+			if result.Failed == nil {
+				result.Failed = make(map[string]string)
+			}
+			result.Failed[action.name] = err.Error()
+			continue
+		}
+		DebugLog("transaction: rolled back " + action.name)
+		result.RolledBack = append(result.RolledBack, action.name)
+	}
+	tx.actions = nil
+	return result
+}
@@ -0,0 +1,104 @@
+package kanto
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+)
+
+func TestTransactionRollback(t *testing.T) {
+	tests := []struct {
+		name string
+		// undo errors of tracked resources, in order of tracking
+		undo       map[string]error
+		tracked    []string
+		rolledBack []string
+		failed     []string
+	}{
+		{
+			name:       "reverse order",
+			tracked:    []string{"spawner", "service", "ingress"},
+			rolledBack: []string{"ingress", "service", "spawner"},
+		},
+		{
+			name:       "missing resource is deleted",
+			tracked:    []string{"spawner", "service"},
+			undo:       map[string]error{"service": apierrors.NewNotFound(api.Resource("services"), "service")},
+			rolledBack: []string{"service", "spawner"},
+		},
+		{
+			name:       "rollback continues after failure",
+			tracked:    []string{"spawner", "service", "ingress"},
+			undo:       map[string]error{"service": errors.New("forbidden")},
+			rolledBack: []string{"ingress", "spawner"},
+			failed:     []string{"service"},
+		},
+		{
+			name:       "nothing tracked",
+			rolledBack: []string{},
+		},
+	}
+	for _, test := range tests {
+		tx := &Transaction{}
+		deleted := []string{}
+		for _, name := range test.tracked {
+			name := name
+			tx.Track(name, func() error {
+				deleted = append(deleted, name)
+				return test.undo[name]
+			})
+		}
+		cause := errors.New("pods not ready")
+		result := tx.Rollback(cause)
+
+		if result.Err != cause || result.Cause != cause.Error() {
+			t.Errorf("%s: cause = %v, want %v", test.name, result.Err, cause)
+		}
+		if !reflect.DeepEqual(result.RolledBack, test.rolledBack) {
+			t.Errorf("%s: rolled back %v, want %v", test.name, result.RolledBack, test.rolledBack)
+		}
+		if len(result.Failed) != len(test.failed) {
+			t.Errorf("%s: failed %v, want %v", test.name, result.Failed, test.failed)
+		}
+		for _, name := range test.failed {
+			if _, ok := result.Failed[name]; !ok {
+				t.Errorf("%s: %s is not in failed %v", test.name, name, result.Failed)
+			}
+		}
+		// every undo is called once, in reverse order
+		for i, name := range deleted {
+			if want := test.tracked[len(test.tracked)-1-i]; name != want {
+				t.Errorf("%s: undo %d called for %s, want %s", test.name, i, name, want)
+			}
+		}
+
+		// rolled back transaction is empty
+		if again := tx.Rollback(cause); len(again.RolledBack) != 0 {
+			t.Errorf("%s: second rollback rolled back %v", test.name, again.RolledBack)
+		}
+	}
+}
+
+func TestRollbackErrorMessage(t *testing.T) {
+	result := &RollbackError{Cause: "pods not ready", RolledBack: []string{"ingress"},
+		Failed: map[string]string{"service": "forbidden", "budget": "timeout", "spawner": "conflict"}}
+	want := "pods not ready; rolled back: ingress; rollback failed: budget (timeout), service (forbidden), spawner (conflict)"
+	// map order changes between runs, message must not
+	for i := 0; i < 10; i++ {
+		if msg := result.Error(); msg != want {
+			t.Fatalf("Error() = %q, want %q", msg, want)
+		}
+	}
+}
+
+func TestNilTransaction(t *testing.T) {
+	var tx *Transaction
+	tx.Track("service", func() error { return nil })
+	result := tx.Rollback(errors.New("create failed"))
+	if len(result.RolledBack) != 0 || len(result.Failed) != 0 {
+		t.Errorf("nil transaction rolled back %v, failed %v", result.RolledBack, result.Failed)
+	}
+}
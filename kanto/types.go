@@ -8,6 +8,8 @@ package kanto
 
 import (
 	"encoding/json"
+
+	"k8s.io/kubernetes/pkg/api"
 )
 
 
@@ -29,6 +31,27 @@ type CouchdbCluster struct {
 	Endpoint  string `json:",omitempty"`
 	// kubernetes namespace, where this cluster belongs
 	Namespace string `json:",omitempty"`
+	// cpu and memory requests and limits of couchdb containers, saved in cluster service annotation
+	Resources *api.ResourceRequirements `json:",omitempty"`
+	// size and storage class of volume claims, saved in cluster service annotation
+	Storage *ClusterStorage `json:",omitempty"`
+	// anti-affinity and zone spreading of replicas, saved in cluster service annotation
+	Placement *ClusterPlacement `json:",omitempty"`
+	// how cluster is reachable from outside of kubernetes, saved in cluster service annotation
+	Exposure string `json:",omitempty"`
+	// type of spawner that runs cluster pods, saved in cluster service annotation
+	SpawnerType string `json:",omitempty"`
+	// replication reconcile status, only in cluster detail and list
+	Reconcile *ReconcileStatus `json:",omitempty"`
+	// databases replicated between all replicas
+	Databases []string `json:",omitempty"`
+	// running asynchronous operation, nil if cluster is not changed via operation
+	Operation *Operation `json:"-"`
+	// resources created during cluster creation, deleted if creation fails
+	Transaction *Transaction `json:"-"`
+	// cluster is only read (list, detail), kubernetes objects can be read from KUBE_CACHE
+	// operations leave it false, they need current state from api
+	ReadCache bool `json:"-"`
 }
 
 // couchdb struct for couchdb user (database _users)
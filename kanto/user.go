@@ -3,7 +3,7 @@
 // author: Vaclav Rozsypalek
 // Created on 21.06.2016
 
-// mostly dummy functions for handling operation with users
+// functions for handling operation with users
 // ie authentication, validation etc
 package kanto
 
@@ -15,14 +15,17 @@ type User struct {
 	// TODO
 }
 
-// valid username and its token against database
-// DUMMY function, not implemented
+// valid username and its token against configured authenticator
 // @param none
 // @return bool - true if authentication was successful
 func (u *User) IsAuthenticated() bool {
-	// DUMMY function, not implemented
-	// DUMMY
-	return true
+	ok, err := AUTHENTICATOR.Authenticate(u.UserName, u.Token)
+	if err != nil {
+		ErrorLog("user: authentication error for user: " + u.UserName)
+		ErrorLog(err)
+		return false
+	}
+	return ok
 }
 
 // parse user from HTTP POST request
@@ -40,26 +43,21 @@ func ParseUser(r *http.Request) (u *User) {
 	// done
 	return u
 }
-// dummy db storage
-var dbs_storage map[string][]string = make(map[string][]string)
-func DatabasesToReplicate(username string) ([]string){
-	// DUMMY
-	users_db := dbs_storage[username]
-	if len(users_db) > 0 {
-		return users_db
-	} else {
-		return []string{"test", "_users"}
-	}
 
+// parse user from http basic auth in Authorization header
+// and return initialized User struct
+// @param r * http.Request - request send to REST API with credentials in header
+// @return u - fully initialised user Struct with data from http request
+func ParseUserFromHeader(r *http.Request) (u *User) {
+	// username and token, empty if header is missing
+	username, token, _ := r.BasicAuth()
 
-}
+	// init a user struct
+	u = &User{UserName: username, Token: token}
 
-func SaveReplDatabases(username string, dbs []string) {
-	// DUMMY
-	users_db := dbs_storage[username]
-	if len(users_db) > 0 {
-		dbs_storage[username] = append(users_db, dbs...)
-	} else {
-		dbs_storage[username] = append([]string{"test", "_users"}, dbs...)
-	}
+	// done
+	return u
 }
+
+// databases that are replicated by default in every cluster
+var DEFAULT_REPL_DATABASES = []string{"test", "_users"}
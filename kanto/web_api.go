@@ -12,7 +12,6 @@ import (
 	"io"
 	"encoding/json"
 	"errors"
-	"k8s.io/kubernetes/pkg/api"
 	"strings"
 )
 
@@ -33,6 +32,15 @@ func ConfigureWebHandlers() *http.ServeMux {
 	mux.HandleFunc("/v0/delete", deleteDatabase)
 	mux.HandleFunc("/v0/scale", scaleDatabase)
 	mux.HandleFunc("/v0/replicate", replicateDatabase)
+	mux.HandleFunc("/v0/operations/", operationDetail)
+	mux.HandleFunc("/v0/credentials", credentialsDatabase)
+	mux.HandleFunc("/v0/rotate", rotateCredentialsDatabase)
+	mux.HandleFunc("/v0/resize", resizeDatabase)
+
+	// resource oriented API
+	mux.HandleFunc("/v1/clusters", v1ClustersHandler)
+	mux.HandleFunc("/v1/clusters/", v1ClusterHandler)
+	mux.HandleFunc("/v1/operations/", v1OperationHandler)
 
 	// default handler for other requests
 	mux.HandleFunc("/", defaultHandler)
@@ -46,10 +54,8 @@ func createDatabase(w http.ResponseWriter, r *http.Request) {
 	// get user credentials from request
 	user := ParseUser(r)
 	// check for valid user credentials
-	// dummy check
 	if !user.IsAuthenticated() {
 		// sorry
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		unauthorized(w)
 		return
 	}
@@ -77,16 +83,59 @@ func createDatabase(w http.ResponseWriter, r *http.Request) {
 	labels := make(map[string]string)
 	labels[LABEL_USER] = user.UserName
 	labels[LABEL_CLUSTER_TAG] = cluster_tag
-	// init cluster struct
-	couchdb_cluster := &CouchdbCluster{Tag: cluster_tag, Replicas: int32(replicas), Username: user.UserName,
-					Namespace: api.NamespaceDefault, Labels: labels, Password: user.Token}
-
-	// create db cluster
-	err := couchdb_cluster.CreateCouchdbCluster()
 
 	// prepare response
 	result := KantoResponse{}
 
+	// cpu and memory of couchdb containers, requested values replace operator defaults
+	resources, err := ParseResourcesForm(r)
+	if err == nil {
+		resources = MergeResources(&DEFAULT_RESOURCES, resources)
+		err = ValidateResources(resources)
+	}
+	// size and class of volume claims, requested values replace operator defaults
+	var storage *ClusterStorage
+	if err == nil {
+		storage, err = ParseStorageForm(r)
+	}
+	if err == nil {
+		storage = MergeStorage(&DEFAULT_STORAGE, storage)
+		err = ValidateStorage(storage)
+	}
+	// anti-affinity and zone spreading of replicas, requested values replace operator defaults
+	var placement *ClusterPlacement
+	if err == nil {
+		placement, err = ParsePlacementForm(r)
+	}
+	if err == nil {
+		placement = MergePlacement(&DEFAULT_PLACEMENT, placement)
+		err = ValidatePlacement(placement)
+	}
+	// exposure of cluster outside of kubernetes, operator default if not set
+	exposure := r.FormValue("exposure")
+	if exposure == "" {
+		exposure = DEFAULT_EXPOSURE
+	}
+	if err == nil {
+		err = ValidateExposure(exposure)
+	}
+
+	// generate couchdb admin password for this cluster
+	var password string
+	if err == nil {
+		password, err = GeneratePassword()
+	}
+	var op *Operation
+	if err == nil {
+		// init cluster struct
+		couchdb_cluster := &CouchdbCluster{Tag: cluster_tag, Replicas: int32(replicas), Username: user.UserName,
+						Namespace: TENANTS.Namespace(user.UserName), Labels: labels, Password: password,
+						Resources: resources, Storage: storage, Placement: placement, Exposure: exposure}
+
+		// create db cluster in background
+		op, err = OPERATIONS.Start(OPERATION_CREATE, couchdb_cluster, couchdb_cluster.CreateCouchdbCluster)
+	}
+
 	// check for errors
 	if err != nil {
 		// fail response
@@ -95,11 +144,10 @@ func createDatabase(w http.ResponseWriter, r *http.Request) {
 		result.Error = err.Error()
 	} else {
 		result.Status = STATUS_OK
-		result.StatusMessage = "couchdb cluster creation successfull for cluster_tag: "+cluster_tag
-		// print created cluster info
-		cluster_info, _ := json.Marshal(*couchdb_cluster)
-		result.Result = (*json.RawMessage)(&cluster_info)
-
+		result.StatusMessage = "couchdb cluster creation started for cluster_tag: "+cluster_tag+", operation id: "+op.Id
+		// print operation info, admin password is returned only here
+		op_info, _ := op.MarshalWithPassword(password)
+		result.Result = (*json.RawMessage)(&op_info)
 	}
 	// marshal response to JSON
 	result_json, _ := json.Marshal(result)
@@ -115,7 +163,6 @@ func deleteDatabase(w http.ResponseWriter, r *http.Request) {
 	// check for valid user credentials
 	if !user.IsAuthenticated() {
 		// sorry
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		unauthorized(w)
 		return
 	}
@@ -129,7 +176,7 @@ func deleteDatabase(w http.ResponseWriter, r *http.Request) {
 
 	// init cluster struct
 	couchdb_cluster := &CouchdbCluster{Tag: cluster_tag, Username: user.UserName,
-					Namespace: api.NamespaceDefault, Labels: labels}
+					Namespace: TENANTS.Namespace(user.UserName), Labels: labels}
 	// prepare response
 	result := KantoResponse{}
 
@@ -142,8 +189,8 @@ func deleteDatabase(w http.ResponseWriter, r *http.Request) {
 		result.StatusMessage = "couchdb cluster deletion failed error: invalid or non-existing cluster tag"
 		result.Error = err.Error()
 	} else {
-		// delete couchdb cluster
-		err = couchdb_cluster.DeleteCouchdbCluster()
+		// delete couchdb cluster in background
+		op, err := OPERATIONS.Start(OPERATION_DELETE, couchdb_cluster, couchdb_cluster.DeleteCouchdbCluster)
 
 		// check for errors
 		if err != nil {
@@ -153,7 +200,9 @@ func deleteDatabase(w http.ResponseWriter, r *http.Request) {
 			result.Error = err.Error()
 		} else {
 			result.Status = STATUS_OK
-			result.StatusMessage = "couchdb cluster deletion successfull for cluster_tag: "+cluster_tag
+			result.StatusMessage = "couchdb cluster deletion started for cluster_tag: "+cluster_tag+", operation id: "+op.Id
+			// print operation info
+			operationResult(&result, op)
 		}
 	}
 
@@ -171,7 +220,6 @@ func scaleDatabase(w http.ResponseWriter, r *http.Request) {
 	// check for valid user credentials
 	if !user.IsAuthenticated() {
 		// sorry
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		unauthorized(w)
 		return
 	}
@@ -192,14 +240,27 @@ func scaleDatabase(w http.ResponseWriter, r *http.Request) {
 	labels[LABEL_CLUSTER_TAG] = cluster_tag
 	// init cluster struct
 	couchdb_cluster := &CouchdbCluster{Tag: cluster_tag, Replicas: int32(replicas), Username: user.UserName,
-					Namespace: api.NamespaceDefault, Labels: labels, Password: user.Token}
+					Namespace: TENANTS.Namespace(user.UserName), Labels: labels, Password: user.Token}
 
 	// prepare response
 	result := KantoResponse{}
 
+	// requested cpu and memory, values not set are kept
+	resources, err := ParseResourcesForm(r)
+
 	// check if  cluster tag belong to this user or if its even exist
-	var err error
-	if service, _ := couchdb_cluster.GetClusterService(); service == nil {
+	service, _ := couchdb_cluster.GetClusterService()
+	if err == nil && resources != nil {
+		resources = MergeResources(ResourcesOf(service), resources)
+		err = ValidateResources(resources)
+		couchdb_cluster.Resources = resources
+	}
+	if err != nil {
+		// fail response
+		result.Status = STATUS_ERROR
+		result.StatusMessage = "couchdb cluster scaling failed, invalid resources"
+		result.Error = err.Error()
+	} else if service == nil {
 		// no deployment found,  throw an error
 		err = errors.New("invalid or non-existing cluster tag")
 		// fail response
@@ -207,7 +268,8 @@ func scaleDatabase(w http.ResponseWriter, r *http.Request) {
 		result.StatusMessage = "couchdb cluster scaling failed, invalid or non-existing cluster tag"
 		result.Error = err.Error()
 	} else {
-		err = couchdb_cluster.ScaleCouchdbCluster()
+		// scale couchdb cluster in background
+		op, err := OPERATIONS.Start(OPERATION_SCALE, couchdb_cluster, couchdb_cluster.ScaleCouchdbCluster)
 			// check for errors
 		if err != nil {
 			// fail response
@@ -216,10 +278,9 @@ func scaleDatabase(w http.ResponseWriter, r *http.Request) {
 			result.Error = err.Error()
 		} else {
 			result.Status = STATUS_OK
-			result.StatusMessage = "couchdb cluster scaling successfull for cluster_tag: "+cluster_tag
-			// print scaled cluster info
-			cluster_info, _ := json.Marshal(*couchdb_cluster)
-			result.Result = (*json.RawMessage)(&cluster_info)
+			result.StatusMessage = "couchdb cluster scaling started for cluster_tag: "+cluster_tag+", operation id: "+op.Id
+			// print operation info
+			operationResult(&result, op)
 		}
 	}
 
@@ -238,7 +299,6 @@ func replicateDatabase(w http.ResponseWriter, r *http.Request) {
 	// check for valid user credentials
 	if !user.IsAuthenticated() {
 		// sorry
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		unauthorized(w)
 		return
 	}
@@ -253,7 +313,7 @@ func replicateDatabase(w http.ResponseWriter, r *http.Request) {
 	labels[LABEL_CLUSTER_TAG] = cluster_tag
 	// init cluster struct
 	couchdb_cluster := &CouchdbCluster{Tag: cluster_tag, Username: user.UserName,
-					Namespace: api.NamespaceDefault, Labels: labels, Password: user.Token}
+					Namespace: TENANTS.Namespace(user.UserName), Labels: labels, Password: user.Token}
 
 	// prepare response
 	result := KantoResponse{}
@@ -267,24 +327,12 @@ func replicateDatabase(w http.ResponseWriter, r *http.Request) {
 		result.StatusMessage = "couchdb cluster configure db replication failed, cannot find cluster"
 		result.Error = err.Error()
 	} else {
-		// get replica number
-		if SPAWNER_TYPE == COMPONENT_DEPLOYMENT {
-			deployment, _ := couchdb_cluster.GetDeployment()
-			// save replicas number
-			couchdb_cluster.Replicas = deployment.Spec.Replicas
-		} else if SPAWNER_TYPE == COMPONENT_RC {
-			// get rcs
-			rcs, _ := couchdb_cluster.GetReplicationControllers()
-			// save replicas number
-			couchdb_cluster.Replicas = int32(len(*rcs))
-		}
-
-		// setup replication for specified databases
-		err := couchdb_cluster.SetupReplication(databases)
+		// configure replication in background
+		op, err := OPERATIONS.Start(OPERATION_REPLICATE, couchdb_cluster, func() error {
+			return couchdb_cluster.ReplicateDatabases(databases)
+		})
 
 		if err != nil {
-			ErrorLog("web_api - replicate DB : setup replication")
-			ErrorLog(err)
 			// fail response
 			result.Status = STATUS_ERROR
 			result.StatusMessage = "couchdb cluster configure db replication failed"
@@ -292,13 +340,12 @@ func replicateDatabase(w http.ResponseWriter, r *http.Request) {
 		} else {
 			// everything is OK
 			result.Status = STATUS_OK
-			result.StatusMessage = "couchdb cluster configure db replication successfull for cluster_tag: "+cluster_tag
+			result.StatusMessage = "couchdb cluster configure db replication started for cluster_tag: "+cluster_tag+", operation id: "+op.Id
+			// print operation info
+			operationResult(&result, op)
 		}
 	}
 
-	// save requested dbs to persistent storage, so we can reconfigure replication later
-	SaveReplDatabases(couchdb_cluster.Username, databases)
-
 	// marshal response to JSON
 	result_json, _ := json.Marshal(result)
 	// write json result
@@ -307,6 +354,125 @@ func replicateDatabase(w http.ResponseWriter, r *http.Request) {
 }
 
 
+// http handler
+// rotate couchdb admin password of database cluster
+func rotateCredentialsDatabase(w http.ResponseWriter, r *http.Request) {
+	// get user credentials from request
+	user := ParseUser(r)
+	// check for valid user credentials
+	if !user.IsAuthenticated() {
+		// sorry
+		unauthorized(w)
+		return
+	}
+
+	// cluster tag
+	cluster_tag := r.FormValue("cluster_tag")
+	// init cluster struct, token is admin password of clusters without secret
+	couchdb_cluster := NewCouchdbCluster(user.UserName, cluster_tag, TENANTS.Namespace(user.UserName))
+	couchdb_cluster.Password = user.Token
+
+	// prepare response
+	result := KantoResponse{}
+
+	_, err := couchdb_cluster.GetClusterService()
+	if err != nil {
+		ErrorLog("web_api - rotate credentials : get service error")
+		ErrorLog(err)
+		// fail response
+		result.Status = STATUS_ERROR
+		result.StatusMessage = "couchdb cluster rotate credentials failed, cannot find cluster"
+		result.Error = err.Error()
+	} else {
+		// rotate password in background
+		op, err := OPERATIONS.Start(OPERATION_ROTATE, couchdb_cluster, couchdb_cluster.RotateCredentials)
+
+		if err != nil {
+			// fail response
+			result.Status = STATUS_ERROR
+			result.StatusMessage = "couchdb cluster rotate credentials failed"
+			result.Error = err.Error()
+		} else {
+			// everything is OK
+			result.Status = STATUS_OK
+			result.StatusMessage = "couchdb cluster rotate credentials started for cluster_tag: "+cluster_tag+", operation id: "+op.Id
+			// print operation info
+			operationResult(&result, op)
+		}
+	}
+
+	// marshal response to JSON
+	result_json, _ := json.Marshal(result)
+	// write json result
+	io.WriteString(w, string(result_json))
+}
+
+// http handler
+// resize volumes of all pods in database cluster
+func resizeDatabase(w http.ResponseWriter, r *http.Request) {
+	// get user credentials from request
+	user := ParseUser(r)
+	// check for valid user credentials
+	if !user.IsAuthenticated() {
+		// sorry
+		unauthorized(w)
+		return
+	}
+
+	// cluster tag
+	cluster_tag := r.FormValue("cluster_tag")
+	// init cluster struct, token is admin password of clusters without secret
+	couchdb_cluster := NewCouchdbCluster(user.UserName, cluster_tag, TENANTS.Namespace(user.UserName))
+	couchdb_cluster.Password = user.Token
+
+	// prepare response
+	result := KantoResponse{}
+
+	// requested size
+	storage, err := ParseStorageForm(r)
+	if err != nil {
+		result.Status = STATUS_ERROR
+		result.StatusMessage = "couchdb cluster resize failed, invalid storage size"
+		result.Error = err.Error()
+	} else if service, err := couchdb_cluster.GetClusterService(); err != nil {
+		ErrorLog("web_api - resize : get service error")
+		ErrorLog(err)
+		// fail response
+		result.Status = STATUS_ERROR
+		result.StatusMessage = "couchdb cluster resize failed, cannot find cluster"
+		result.Error = err.Error()
+	} else if err = ResizeSupported(SpawnerTypeOf(service)); err != nil {
+		result.Status = STATUS_ERROR
+		result.StatusMessage = "couchdb cluster resize failed, cluster volumes cannot be resized"
+		result.Error = err.Error()
+	} else if couchdb_cluster.Storage, err = ResizedStorage(StorageOf(service), storage); err != nil {
+		result.Status = STATUS_ERROR
+		result.StatusMessage = "couchdb cluster resize failed, invalid storage size"
+		result.Error = err.Error()
+	} else {
+		// resize volumes in background
+		op, err := OPERATIONS.Start(OPERATION_RESIZE, couchdb_cluster, couchdb_cluster.ResizeCouchdbCluster)
+
+		if err != nil {
+			// fail response
+			result.Status = STATUS_ERROR
+			result.StatusMessage = "couchdb cluster resize failed"
+			result.Error = err.Error()
+		} else {
+			// everything is OK
+			result.Status = STATUS_OK
+			result.StatusMessage = "couchdb cluster resize started for cluster_tag: "+cluster_tag+", operation id: "+op.Id
+			// print operation info
+			operationResult(&result, op)
+		}
+	}
+
+	// marshal response to JSON
+	result_json, _ := json.Marshal(result)
+	// write json result
+	io.WriteString(w, string(result_json))
+}
+
 // http handler
 // list all databases clusters that belong to user
 func listDatabases(w http.ResponseWriter, r *http.Request) {
@@ -315,12 +481,11 @@ func listDatabases(w http.ResponseWriter, r *http.Request) {
 	// check for valid user credentials
 	if !user.IsAuthenticated() {
 		// sorry
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		unauthorized(w)
 		return
 	}
 	// get all couchdb clusters for this user
-	clusters, err := ListCouchdbClusters(user.UserName, api.NamespaceDefault)
+	clusters, err := ListCouchdbClusters(user.UserName, TENANTS.Namespace(user.UserName))
 
 	// prepare response
 	result := KantoResponse{}
@@ -354,7 +519,6 @@ func detailDatabase(w http.ResponseWriter, r *http.Request) {
 	// check for valid user credentials
 	if !user.IsAuthenticated() {
 		// sorry
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		unauthorized(w)
 		return
 	}
@@ -365,8 +529,9 @@ func detailDatabase(w http.ResponseWriter, r *http.Request) {
 	labels[LABEL_USER] = user.UserName
 	labels[LABEL_CLUSTER_TAG] = cluster_tag
 	// init cluster struct
+	// detail only reads cluster, so it can be answered from cache
 	couchdb_cluster := &CouchdbCluster{Tag: cluster_tag, Username: user.UserName,
-					Namespace: api.NamespaceDefault, Labels: labels}
+					Namespace: TENANTS.Namespace(user.UserName), Labels: labels, ReadCache: true}
 
 	// prepare response
 	result := KantoResponse{}
@@ -382,18 +547,26 @@ func detailDatabase(w http.ResponseWriter, r *http.Request) {
 		result.Error = err.Error()
 	} else {
 		// load active replicas
-		if SPAWNER_TYPE == COMPONENT_DEPLOYMENT {
-			deployment, _ := couchdb_cluster.GetDeployment()
-			// save replicas number
-			couchdb_cluster.Replicas = deployment.Spec.Replicas
-		} else if SPAWNER_TYPE == COMPONENT_RC {
-			// get rcs
-			rcs, _ := couchdb_cluster.GetReplicationControllers()
-			// save replicas number
-			couchdb_cluster.Replicas = int32(len(*rcs))
-		}
+		couchdb_cluster.LoadReplicas()
 		// endpoint
-		couchdb_cluster.Endpoint = ClusterEndpoint(service.Spec.ClusterIP)
+		couchdb_cluster.Endpoint = ServiceEndpoint(service)
+		couchdb_cluster.Exposure = ExposureOf(service)
+		// cpu and memory of couchdb containers
+		couchdb_cluster.Resources = ResourcesOf(service)
+		// volume claim size and storage class
+		couchdb_cluster.Storage = StorageOf(service)
+		// anti-affinity and zone spreading of replicas
+		couchdb_cluster.Placement = PlacementOf(service)
+		// replication reconcile status
+		couchdb_cluster.Reconcile = RECONCILER.Status(couchdb_cluster)
+		// replicated databases
+		couchdb_cluster.Databases, err = couchdb_cluster.DatabasesToReplicate()
+		if err != nil {
+			// fail response
+			result.Status = STATUS_ERROR
+			result.StatusMessage = "couchdb cluster detail failed, cannot load replicated databases"
+			result.Error = err.Error()
+		}
 	}
 	// no errors
 	if err == nil  {
@@ -410,6 +583,90 @@ func detailDatabase(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, string(result_json))
 }
 
+// http handler
+// show couchdb admin credentials of specified database cluster
+func credentialsDatabase(w http.ResponseWriter, r *http.Request) {
+	// get user credentials from request
+	user := ParseUser(r)
+	// check for valid user credentials
+	if !user.IsAuthenticated() {
+		// sorry
+		unauthorized(w)
+		return
+	}
+	// cluster tag
+	cluster_tag := r.FormValue("cluster_tag")
+	// init cluster struct
+	couchdb_cluster := NewCouchdbCluster(user.UserName, cluster_tag, TENANTS.Namespace(user.UserName))
+
+	// prepare response
+	result := KantoResponse{}
+
+	// check if cluster tag belong to this user
+	service, err := couchdb_cluster.GetClusterService()
+	if err == nil {
+		err = couchdb_cluster.LoadCredentials()
+	}
+	if err != nil {
+		// fail response
+		result.Status = STATUS_ERROR
+		result.StatusMessage = "couchdb cluster credentials failed"
+		result.Error = err.Error()
+	} else {
+		result.Status = STATUS_OK
+		result.StatusMessage = "couchdb cluster credentials successfull for cluster_tag: "+cluster_tag
+		couchdb_cluster.Endpoint = ServiceEndpoint(service)
+		// print cluster info with password
+		cluster_info, _ := json.Marshal(*couchdb_cluster)
+		result.Result = (*json.RawMessage)(&cluster_info)
+	}
+	// marshal response to JSON
+	result_json, _ := json.Marshal(result)
+	// write json result
+	io.WriteString(w, string(result_json))
+}
+
+// http handler
+// show status and progress of asynchronous operation
+func operationDetail(w http.ResponseWriter, r *http.Request) {
+	// get user credentials from request
+	user := ParseUser(r)
+	// check for valid user credentials
+	if !user.IsAuthenticated() {
+		// sorry
+		unauthorized(w)
+		return
+	}
+	// operation id from path
+	id := strings.TrimPrefix(r.URL.Path, "/v0/operations/")
+
+	// prepare response
+	result := KantoResponse{}
+
+	// only owner can see operation
+	op := OPERATIONS.Get(id, user.UserName)
+	if op == nil {
+		result.Status = STATUS_ERROR
+		result.StatusMessage = "operation detail failed"
+		result.Error = "invalid or non-existing operation id"
+	} else {
+		result.Status = STATUS_OK
+		result.StatusMessage = "operation "+op.Id+" detail successfull"
+		// print operation info
+		operationResult(&result, op)
+	}
+	// marshal response to JSON
+	result_json, _ := json.Marshal(result)
+	// write json result
+	io.WriteString(w, string(result_json))
+}
+
+// save operation info to response result
+func operationResult(result *KantoResponse, op *Operation) {
+	op_info, _ := json.Marshal(op)
+	result.Result = (*json.RawMessage)(&op_info)
+}
+
 // default handler for request with bad path
 func defaultHandler(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, "Welcome to Kanto Web-Service v 0.1 \n" +
@@ -419,18 +676,30 @@ func defaultHandler(w http.ResponseWriter, r *http.Request) {
 			" - detail  	/v0/detail \n" +
 			" - list  	/v0/list \n" +
 			" - scale  	/v0/scale \n" +
-			" - replicate  	/v0/replicate \n\n"+
+			" - replicate  	/v0/replicate \n"+
+			" - resize  	/v0/resize \n"+
+			" - operation  	/v0/operations/{id} \n"+
+			" - credentials  	/v0/credentials \n"+
+			" - rotate credentials  	/v0/rotate \n\n"+
+			"resource oriented API: \n" +
+			" - list, create  			GET, POST	/v1/clusters \n" +
+			" - detail, scale, drop  		GET, PATCH, DELETE	/v1/clusters/{tag} \n" +
+			" - replicate  			PUT	/v1/clusters/{tag}/replication \n"+
+			" - resize  			PUT	/v1/clusters/{tag}/storage \n"+
+			" - operation  			GET	/v1/operations/{id} \n\n"+
 			"check README.md for more info about API\n")
 }
 
-// return unauthorised response
+// return unauthorised response with http status 401
 func unauthorized(w http.ResponseWriter) {
 	// prepare response
 	result := KantoResponse{Status:STATUS_UNAUTHORIZED, StatusMessage:"Authetication failed"}
 
 	// marshal response to JSON
 	result_json, _ := json.Marshal(result)
-	// write json result
+	// write status code and json result
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
 	io.WriteString(w, string(result_json))
 }
 
@@ -0,0 +1,496 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// file for resource oriented web service API (v1)
+// requests and responses are JSON, credentials are sent in Authorization header (http basic auth)
+// and result of operation is reflected in http status code
+package kanto
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	V1_CLUSTERS_PATH   = "/v1/clusters"
+	V1_OPERATIONS_PATH = "/v1/operations"
+	V1_REPLICATION     = "replication"
+	V1_CREDENTIALS     = "credentials"
+	V1_STORAGE         = "storage"
+
+	// http status for valid request with invalid values, not defined in net/http
+	STATUS_UNPROCESSABLE_ENTITY = 422
+)
+
+// validation messages, built from same limits that are checked
+var V1_INVALID_TAG_MESSAGE = "invalid cluster tag, tag has to be " + strconv.Itoa(MIN_CLUSTER_TAG) + "-" + strconv.Itoa(MAX_CLUSTER_TAG) +
+	" characters long and contain only lowercase letters, numbers and \"-\""
+var V1_INVALID_REPLICAS_MESSAGE = "invalid replicas, replicas has to be number between 1-" + strconv.Itoa(MAX_REPLICAS)
+
+// http handler for cluster collection
+// GET - list all clusters of user
+// POST - create new cluster
+func v1ClustersHandler(w http.ResponseWriter, r *http.Request) {
+	// get user credentials from Authorization header
+	user := ParseUserFromHeader(r)
+	if !user.IsAuthenticated() {
+		// sorry
+		unauthorized(w)
+		return
+	}
+
+	switch r.Method {
+	case METHOD_GET:
+		v1ListClusters(w, user)
+	case METHOD_POST:
+		v1CreateCluster(w, r, user)
+	default:
+		v1MethodNotAllowed(w, METHOD_GET, METHOD_POST)
+	}
+}
+
+// http handler for single cluster
+// GET, PATCH, DELETE /v1/clusters/{tag}
+// PUT /v1/clusters/{tag}/replication
+// PUT /v1/clusters/{tag}/storage - resize volumes
+// GET /v1/clusters/{tag}/credentials
+// POST /v1/clusters/{tag}/credentials - rotate admin password
+func v1ClusterHandler(w http.ResponseWriter, r *http.Request) {
+	// get user credentials from Authorization header
+	user := ParseUserFromHeader(r)
+	if !user.IsAuthenticated() {
+		// sorry
+		unauthorized(w)
+		return
+	}
+
+	// parse path, {tag}, {tag}/replication or {tag}/credentials
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, V1_CLUSTERS_PATH), "/")
+	parts := strings.Split(path, "/")
+	if path == "" || len(parts) > 2 || (len(parts) == 2 && parts[1] != V1_REPLICATION && parts[1] != V1_CREDENTIALS &&
+		parts[1] != V1_STORAGE) {
+		v1Error(w, http.StatusNotFound, "unknown resource: "+r.URL.Path, nil)
+		return
+	}
+
+	// init cluster struct
+	// clusters created before generated admin credentials use user token as password
+	couchdb_cluster := NewCouchdbCluster(user.UserName, parts[0], TENANTS.Namespace(user.UserName))
+	couchdb_cluster.Password = user.Token
+
+	// sub resource credentials
+	if len(parts) == 2 && parts[1] == V1_CREDENTIALS {
+		switch r.Method {
+		case METHOD_GET:
+			v1ClusterCredentials(w, couchdb_cluster)
+		case METHOD_POST:
+			v1RotateCredentials(w, couchdb_cluster)
+		default:
+			v1MethodNotAllowed(w, METHOD_GET, METHOD_POST)
+		}
+		return
+	}
+	// sub resource storage
+	if len(parts) == 2 && parts[1] == V1_STORAGE {
+		if r.Method != METHOD_PUT {
+			v1MethodNotAllowed(w, METHOD_PUT)
+			return
+		}
+		v1ResizeCluster(w, r, couchdb_cluster)
+		return
+	}
+	// sub resource replication
+	if len(parts) == 2 {
+		if r.Method != METHOD_PUT {
+			v1MethodNotAllowed(w, METHOD_PUT)
+			return
+		}
+		v1ReplicateCluster(w, r, couchdb_cluster)
+		return
+	}
+
+	switch r.Method {
+	case METHOD_GET:
+		v1DetailCluster(w, couchdb_cluster)
+	case METHOD_PATCH:
+		v1ScaleCluster(w, r, couchdb_cluster)
+	case METHOD_DELETE:
+		v1DeleteCluster(w, couchdb_cluster)
+	default:
+		v1MethodNotAllowed(w, METHOD_GET, METHOD_PATCH, METHOD_DELETE)
+	}
+}
+
+// rotate admin password of cluster, new password is available via GET credentials when operation finishes
+func v1RotateCredentials(w http.ResponseWriter, couchdb_cluster *CouchdbCluster) {
+	if _, ok := v1FindCluster(w, couchdb_cluster); !ok {
+		return
+	}
+	// rotate password in background
+	op, err := OPERATIONS.Start(OPERATION_ROTATE, couchdb_cluster, couchdb_cluster.RotateCredentials)
+	v1OperationStarted(w, op, err, "couchdb cluster rotate credentials")
+}
+
+// list all clusters of user
+func v1ListClusters(w http.ResponseWriter, user *User) {
+	// get all couchdb clusters for this user
+	clusters, err := ListCouchdbClusters(user.UserName, TENANTS.Namespace(user.UserName))
+	if err != nil {
+		v1Error(w, http.StatusInternalServerError, "couchdb list clusters failed", err)
+		return
+	}
+	v1Result(w, http.StatusOK, "couchdb list clusters successfull for user: "+user.UserName, *clusters)
+}
+
+// create new cluster
+// request body is CouchdbCluster json, only Tag, Replicas, Resources, Storage, Placement and Exposure are used
+func v1CreateCluster(w http.ResponseWriter, r *http.Request, user *User) {
+	// parse request body
+	request := CouchdbCluster{}
+	if !v1ParseBody(w, r, &request) {
+		return
+	}
+
+	// empty tag means generated tag
+	cluster_tag := request.Tag
+	if cluster_tag == "" {
+		cluster_tag = RandStringName(MAX_CLUSTER_TAG)
+	} else if !ValidClusterTag(cluster_tag) {
+		v1Error(w, STATUS_UNPROCESSABLE_ENTITY, V1_INVALID_TAG_MESSAGE, nil)
+		return
+	}
+	// v1 does not adjust replicas, bad number is an error
+	if request.Replicas < 1 || request.Replicas > MAX_REPLICAS {
+		v1Error(w, STATUS_UNPROCESSABLE_ENTITY, V1_INVALID_REPLICAS_MESSAGE, nil)
+		return
+	}
+	// requested resources replace operator defaults
+	resources := MergeResources(&DEFAULT_RESOURCES, request.Resources)
+	if err := ValidateResources(resources); err != nil {
+		v1Error(w, STATUS_UNPROCESSABLE_ENTITY, "invalid resources", err)
+		return
+	}
+	storage := MergeStorage(&DEFAULT_STORAGE, request.Storage)
+	if err := ValidateStorage(storage); err != nil {
+		v1Error(w, STATUS_UNPROCESSABLE_ENTITY, "invalid storage", err)
+		return
+	}
+	placement := MergePlacement(&DEFAULT_PLACEMENT, request.Placement)
+	if err := ValidatePlacement(placement); err != nil {
+		v1Error(w, STATUS_UNPROCESSABLE_ENTITY, "invalid placement", err)
+		return
+	}
+	exposure := request.Exposure
+	if exposure == "" {
+		exposure = DEFAULT_EXPOSURE
+	}
+	if err := ValidateExposure(exposure); err != nil {
+		v1Error(w, STATUS_UNPROCESSABLE_ENTITY, "invalid exposure", err)
+		return
+	}
+
+	// init cluster struct
+	couchdb_cluster := NewCouchdbCluster(user.UserName, cluster_tag, TENANTS.Namespace(user.UserName))
+	couchdb_cluster.Replicas = request.Replicas
+	couchdb_cluster.Resources = resources
+	couchdb_cluster.Storage = storage
+	couchdb_cluster.Placement = placement
+	couchdb_cluster.Exposure = exposure
+
+	// tag has to be unique
+	exists, err := couchdb_cluster.ClusterExists()
+	if err != nil {
+		v1Error(w, http.StatusInternalServerError, "couchdb cluster creation failed", err)
+		return
+	} else if exists {
+		v1Error(w, http.StatusConflict, "couchdb cluster with cluster_tag "+cluster_tag+" already exists", nil)
+		return
+	}
+
+	// generate couchdb admin password for this cluster
+	password, err := GeneratePassword()
+	if err != nil {
+		v1Error(w, http.StatusInternalServerError, "couchdb cluster creation failed", err)
+		return
+	}
+	couchdb_cluster.Password = password
+
+	// create db cluster in background
+	op, err := OPERATIONS.Start(OPERATION_CREATE, couchdb_cluster, couchdb_cluster.CreateCouchdbCluster)
+	if err != nil {
+		v1OperationStarted(w, op, err, "couchdb cluster creation")
+		return
+	}
+	// admin password is returned only in this response
+	op_info, err := op.MarshalWithPassword(password)
+	if err != nil {
+		v1Error(w, http.StatusInternalServerError, "couchdb cluster creation failed", err)
+		return
+	}
+	w.Header().Set("Location", V1_OPERATIONS_PATH+"/"+op.Id)
+	v1Result(w, http.StatusAccepted, "couchdb cluster creation started for cluster_tag: "+cluster_tag+", operation id: "+op.Id,
+		(*json.RawMessage)(&op_info))
+}
+
+// show admin credentials of cluster
+func v1ClusterCredentials(w http.ResponseWriter, couchdb_cluster *CouchdbCluster) {
+	service, ok := v1FindCluster(w, couchdb_cluster)
+	if !ok {
+		return
+	}
+	// load admin password from cluster secret
+	if err := couchdb_cluster.LoadCredentials(); err != nil {
+		v1Error(w, http.StatusInternalServerError, "couchdb cluster credentials failed", err)
+		return
+	}
+	couchdb_cluster.Endpoint = ServiceEndpoint(service)
+
+	v1Result(w, http.StatusOK, "couchdb cluster credentials successfull for cluster_tag: "+couchdb_cluster.Tag, couchdb_cluster)
+}
+
+// show cluster detail
+func v1DetailCluster(w http.ResponseWriter, couchdb_cluster *CouchdbCluster) {
+	// detail only reads cluster, so it can be answered from cache
+	couchdb_cluster.ReadCache = true
+	service, ok := v1FindCluster(w, couchdb_cluster)
+	if !ok {
+		return
+	}
+	// load active replicas
+	if err := couchdb_cluster.LoadReplicas(); err != nil {
+		v1Error(w, http.StatusInternalServerError, "couchdb cluster detail failed", err)
+		return
+	}
+	// endpoint
+	couchdb_cluster.Endpoint = ServiceEndpoint(service)
+	couchdb_cluster.Exposure = ExposureOf(service)
+	// cpu and memory of couchdb containers
+	couchdb_cluster.Resources = ResourcesOf(service)
+	// volume claim size and storage class
+	couchdb_cluster.Storage = StorageOf(service)
+	// anti-affinity and zone spreading of replicas
+	couchdb_cluster.Placement = PlacementOf(service)
+	// replication reconcile status
+	couchdb_cluster.Reconcile = RECONCILER.Status(couchdb_cluster)
+	// replicated databases
+	databases, err := couchdb_cluster.DatabasesToReplicate()
+	if err != nil {
+		v1Error(w, http.StatusInternalServerError, "couchdb cluster detail failed, cannot load replicated databases", err)
+		return
+	}
+	couchdb_cluster.Databases = databases
+	// admin password is shown only by credentials sub resource
+	couchdb_cluster.Password = ""
+
+	v1Result(w, http.StatusOK, "couchdb cluster detail successfull for cluster_tag: "+couchdb_cluster.Tag, couchdb_cluster)
+}
+
+// scale cluster
+// request body is CouchdbCluster json, only Replicas and Resources are used
+// resources not set in request are kept
+func v1ScaleCluster(w http.ResponseWriter, r *http.Request, couchdb_cluster *CouchdbCluster) {
+	// parse request body
+	request := CouchdbCluster{}
+	if !v1ParseBody(w, r, &request) {
+		return
+	}
+	// missing replicas keep current replica count, so only resources can be changed
+	if request.Replicas < 0 || request.Replicas > MAX_REPLICAS {
+		v1Error(w, STATUS_UNPROCESSABLE_ENTITY, V1_INVALID_REPLICAS_MESSAGE, nil)
+		return
+	}
+	service, ok := v1FindCluster(w, couchdb_cluster)
+	if !ok {
+		return
+	}
+	if request.Replicas == 0 {
+		if err := couchdb_cluster.LoadReplicas(); err != nil {
+			v1Error(w, http.StatusInternalServerError, "cannot load current replica count", err)
+			return
+		}
+	} else {
+		couchdb_cluster.Replicas = request.Replicas
+	}
+	if request.Resources != nil {
+		resources := MergeResources(ResourcesOf(service), request.Resources)
+		if err := ValidateResources(resources); err != nil {
+			v1Error(w, STATUS_UNPROCESSABLE_ENTITY, "invalid resources", err)
+			return
+		}
+		couchdb_cluster.Resources = resources
+	}
+
+	// scale in background
+	op, err := OPERATIONS.Start(OPERATION_SCALE, couchdb_cluster, couchdb_cluster.ScaleCouchdbCluster)
+	v1OperationStarted(w, op, err, "couchdb cluster scaling")
+}
+
+// resize volumes of cluster
+// request body is ClusterStorage json, only Size is used
+func v1ResizeCluster(w http.ResponseWriter, r *http.Request, couchdb_cluster *CouchdbCluster) {
+	// parse request body
+	request := ClusterStorage{}
+	if !v1ParseBody(w, r, &request) {
+		return
+	}
+	service, ok := v1FindCluster(w, couchdb_cluster)
+	if !ok {
+		return
+	}
+	if err := ResizeSupported(SpawnerTypeOf(service)); err != nil {
+		v1Error(w, STATUS_UNPROCESSABLE_ENTITY, "cluster volumes cannot be resized", err)
+		return
+	}
+	storage, err := ResizedStorage(StorageOf(service), &request)
+	if err != nil {
+		v1Error(w, STATUS_UNPROCESSABLE_ENTITY, "invalid storage", err)
+		return
+	}
+	couchdb_cluster.Storage = storage
+
+	// resize in background
+	op, err := OPERATIONS.Start(OPERATION_RESIZE, couchdb_cluster, couchdb_cluster.ResizeCouchdbCluster)
+	v1OperationStarted(w, op, err, "couchdb cluster resize")
+}
+
+// delete cluster
+func v1DeleteCluster(w http.ResponseWriter, couchdb_cluster *CouchdbCluster) {
+	if _, ok := v1FindCluster(w, couchdb_cluster); !ok {
+		return
+	}
+	// delete couchdb cluster in background
+	op, err := OPERATIONS.Start(OPERATION_DELETE, couchdb_cluster, couchdb_cluster.DeleteCouchdbCluster)
+	v1OperationStarted(w, op, err, "couchdb cluster deletion")
+}
+
+// configure replication of databases in cluster
+// request body is CouchdbCluster json, only Databases is used
+func v1ReplicateCluster(w http.ResponseWriter, r *http.Request, couchdb_cluster *CouchdbCluster) {
+	// parse request body
+	request := CouchdbCluster{}
+	if !v1ParseBody(w, r, &request) {
+		return
+	}
+	if len(request.Databases) == 0 {
+		v1Error(w, STATUS_UNPROCESSABLE_ENTITY, "no databases to replicate", nil)
+		return
+	}
+	for _, db := range request.Databases {
+		if db == "" {
+			v1Error(w, STATUS_UNPROCESSABLE_ENTITY, "invalid database name", nil)
+			return
+		}
+	}
+	if _, ok := v1FindCluster(w, couchdb_cluster); !ok {
+		return
+	}
+	// configure replication in background
+	op, err := OPERATIONS.Start(OPERATION_REPLICATE, couchdb_cluster, func() error {
+		return couchdb_cluster.ReplicateDatabases(request.Databases)
+	})
+	v1OperationStarted(w, op, err, "couchdb cluster configure db replication")
+}
+
+// http handler for operation status
+// GET /v1/operations/{id}
+func v1OperationHandler(w http.ResponseWriter, r *http.Request) {
+	// get user credentials from Authorization header
+	user := ParseUserFromHeader(r)
+	if !user.IsAuthenticated() {
+		// sorry
+		unauthorized(w)
+		return
+	}
+	if r.Method != METHOD_GET {
+		v1MethodNotAllowed(w, METHOD_GET)
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, V1_OPERATIONS_PATH), "/")
+
+	// only owner can see operation
+	op := OPERATIONS.Get(id, user.UserName)
+	if op == nil {
+		v1Error(w, http.StatusNotFound, "invalid or non-existing operation id: "+id, nil)
+		return
+	}
+	v1Result(w, http.StatusOK, "operation "+op.Id+" detail successfull", op)
+}
+
+// write response for started operation
+// 202 with operation and its location, 409 if cluster has another running operation
+// @return bool - true if operation was started
+func v1OperationStarted(w http.ResponseWriter, op *Operation, err error, action string) bool {
+	if err == ErrOperationInProgress {
+		v1Error(w, http.StatusConflict, action+" failed", err)
+		return false
+	} else if err != nil {
+		v1Error(w, http.StatusInternalServerError, action+" failed", err)
+		return false
+	}
+	w.Header().Set("Location", V1_OPERATIONS_PATH+"/"+op.Id)
+	v1Result(w, http.StatusAccepted, action+" started for cluster_tag: "+op.ClusterTag+", operation id: "+op.Id, op)
+	return true
+}
+
+// find cluster service, writes 404 response if cluster does not exist
+// @return *api.Service - cluster service
+// @return bool - false if response was already written
+func v1FindCluster(w http.ResponseWriter, couchdb_cluster *CouchdbCluster) (*api.Service, bool) {
+	service, err := couchdb_cluster.GetClusterService()
+	if err == ErrClusterNotFound {
+		v1Error(w, http.StatusNotFound, "invalid or non-existing cluster tag: "+couchdb_cluster.Tag, err)
+		return nil, false
+	} else if err != nil {
+		v1Error(w, http.StatusInternalServerError, "cannot find cluster", err)
+		return nil, false
+	}
+	return service, true
+}
+
+// parse json request body, writes 400 response if body is not valid json
+// @return bool - false if response was already written
+func v1ParseBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		v1Error(w, http.StatusBadRequest, "invalid json request body", err)
+		return false
+	}
+	return true
+}
+
+// write error response
+func v1Error(w http.ResponseWriter, code int, message string, err error) {
+	result := KantoResponse{Status: STATUS_ERROR, StatusMessage: message}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	v1Write(w, code, result)
+}
+
+// write successful response with result
+func v1Result(w http.ResponseWriter, code int, message string, v interface{}) {
+	result := KantoResponse{Status: STATUS_OK, StatusMessage: message}
+	if v != nil {
+		result_json, _ := json.Marshal(v)
+		result.Result = (*json.RawMessage)(&result_json)
+	}
+	v1Write(w, code, result)
+}
+
+// write 405 response with allowed methods
+func v1MethodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	v1Error(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+}
+
+// marshal response to JSON and write it with status code
+func v1Write(w http.ResponseWriter, code int, result KantoResponse) {
+	result_json, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	io.WriteString(w, string(result_json))
+}
@@ -0,0 +1,633 @@
+// Kanto
+// web service to manage and scale couchdb running on kubernetes
+
+// kantoctl - command line tool for kanto API
+//
+// credentials are loaded from config file (default ~/.kantoctl, json with keys "url", "username", "token")
+// and can be overwritten by envs KANTO_URL, KANTO_USERNAME, KANTO_TOKEN
+//
+// exit codes: 0 - ok, 1 - kanto returned other status than "ok" or request failed, 2 - bad usage
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/calvix/kanto/kanto"
+	"github.com/calvix/kanto/kanto/client"
+	"github.com/ghodss/yaml"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
+)
+
+const (
+	DEFAULT_URL         = "http://127.0.0.1:80"
+	DEFAULT_CONFIG_FILE = ".kantoctl"
+	DEFAULT_WAIT        = 10 * time.Minute
+
+	OUTPUT_TABLE = "table"
+	OUTPUT_JSON  = "json"
+	OUTPUT_YAML  = "yaml"
+
+	EXIT_OK    = 0
+	EXIT_ERROR = 1
+	EXIT_USAGE = 2
+)
+
+// kantoctl configuration
+type Config struct {
+	Url      string `json:"url"`
+	Username string `json:"username"`
+	Token    string `json:"token"`
+}
+
+// one kantoctl subcommand
+type command struct {
+	usage string
+	run   func(c *client.Client, out *output, args []string) error
+}
+
+// all subcommands
+var commands = map[string]command{
+	"create":             {"create [--tag TAG] --replicas N [--cpu-request Q] [--cpu-limit Q] [--memory-request Q] [--memory-limit Q] [--storage-size Q] [--storage-class CLASS] [--anti-affinity none|preferred|required] [--spread-zones] [--exposure TYPE] [--wait]", createCommand},
+	"delete":             {"delete TAG [--wait]", deleteCommand},
+	"resize":             {"resize TAG --storage-size Q [--wait]", resizeCommand},
+	"scale":              {"scale TAG --replicas N [--cpu-request Q] [--cpu-limit Q] [--memory-request Q] [--memory-limit Q] [--wait]", scaleCommand},
+	"replicate":          {"replicate TAG --databases db1,db2 [--wait]", replicateCommand},
+	"list":               {"list", listCommand},
+	"detail":             {"detail TAG", detailCommand},
+	"credentials":        {"credentials TAG", credentialsCommand},
+	"rotate-credentials": {"rotate-credentials TAG [--wait]", rotateCredentialsCommand},
+	"wait":               {"wait OPERATION_ID [--timeout 10m]", waitCommand},
+}
+
+// error for bad command usage
+type usageError struct {
+	message string
+}
+
+func (e *usageError) Error() string {
+	return e.message
+}
+
+func main() {
+	// global flags
+	global := flag.NewFlagSet("kantoctl", flag.ContinueOnError)
+	configFile := global.String("config", defaultConfigFile(), "path to config file")
+	format := global.String("o", OUTPUT_TABLE, "output format: table, json or yaml")
+	timeout := global.Duration("request-timeout", client.DEFAULT_TIMEOUT, "timeout for one API request")
+	global.Usage = usage
+	if err := global.Parse(os.Args[1:]); err != nil {
+		os.Exit(EXIT_USAGE)
+	}
+	if global.NArg() < 1 {
+		usage()
+		os.Exit(EXIT_USAGE)
+	}
+	cmd, ok := commands[global.Arg(0)]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown command: "+global.Arg(0))
+		usage()
+		os.Exit(EXIT_USAGE)
+	}
+	if *format != OUTPUT_TABLE && *format != OUTPUT_JSON && *format != OUTPUT_YAML {
+		fmt.Fprintln(os.Stderr, "unknown output format: "+*format)
+		os.Exit(EXIT_USAGE)
+	}
+
+	// credentials
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot load config file: "+err.Error())
+		os.Exit(EXIT_USAGE)
+	}
+	c := client.New(config.Url, config.Username, config.Token)
+	c.SetTimeout(*timeout)
+
+	// run command
+	err = cmd.run(c, &output{format: *format}, global.Args()[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "kantoctl: "+err.Error())
+		if _, ok := err.(*usageError); ok {
+			fmt.Fprintln(os.Stderr, "usage: kantoctl "+cmd.usage)
+			os.Exit(EXIT_USAGE)
+		}
+		os.Exit(EXIT_ERROR)
+	}
+	os.Exit(EXIT_OK)
+}
+
+// print usage of all commands
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: kantoctl [--config FILE] [-o table|json|yaml] [--request-timeout 30s] COMMAND [ARGS]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range []string{"create", "delete", "scale", "resize", "replicate", "list", "detail", "credentials", "rotate-credentials", "wait"} {
+		fmt.Fprintln(os.Stderr, "  "+commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "credentials are loaded from config file and envs KANTO_URL, KANTO_USERNAME, KANTO_TOKEN")
+}
+
+// default config file in user home directory
+func defaultConfigFile() string {
+	return filepath.Join(os.Getenv("HOME"), DEFAULT_CONFIG_FILE)
+}
+
+// load config from file and envs, envs have higher priority
+// missing config file is not an error
+// @param path string - path to config file
+// @return *Config
+// @return error
+func loadConfig(path string) (*Config, error) {
+	config := &Config{Url: DEFAULT_URL}
+	content, err := ioutil.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(content, config); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if env := os.Getenv("KANTO_URL"); env != "" {
+		config.Url = env
+	}
+	if env := os.Getenv("KANTO_USERNAME"); env != "" {
+		config.Username = env
+	}
+	if env := os.Getenv("KANTO_TOKEN"); env != "" {
+		config.Token = env
+	}
+	// allow url without scheme, same as curl
+	if !strings.Contains(config.Url, "://") {
+		config.Url = "http://" + config.Url
+	}
+	return config, nil
+}
+
+// parse command flags, positional arguments can be mixed with flags
+// @param fs *flag.FlagSet
+// @param args []string
+// @return []string - positional arguments
+// @return error
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, &usageError{err.Error()}
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// new flag set for command, errors are reported by kantoctl
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	return fs
+}
+
+// get exactly one positional argument
+func oneArg(positional []string, name string) (string, error) {
+	if len(positional) != 1 {
+		return "", &usageError{"expected exactly one " + name}
+	}
+	return positional[0], nil
+}
+
+func createCommand(c *client.Client, out *output, args []string) error {
+	fs := newFlagSet("create")
+	tag := fs.String("tag", "", "cluster tag, generated if empty")
+	replicas := fs.Int("replicas", 1, "number of replicas")
+	resourceFlags := newResourceFlags(fs)
+	storageSize := fs.String("storage-size", "", "size of volume claim of each pod, ie. 10Gi")
+	storageClass := fs.String("storage-class", "", "storage class of volume claims")
+	antiAffinity := fs.String("anti-affinity", "", "anti-affinity of replicas on nodes: none, preferred or required")
+	spreadZones := fs.Bool("spread-zones", false, "prefer replicas in different zones")
+	exposure := fs.String("exposure", "", "exposure outside of kubernetes: internal, nodeport, loadbalancer or ingress")
+	wait := fs.Bool("wait", false, "wait until operation finishes")
+	timeout := fs.Duration("timeout", DEFAULT_WAIT, "max time to wait")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return &usageError{"unexpected argument: " + positional[0]}
+	}
+	options := &client.CreateOptions{Exposure: *exposure}
+	options.Resources, err = resourceFlags.resources()
+	if err != nil {
+		return err
+	}
+	if *storageSize != "" || *storageClass != "" {
+		options.Storage = &kanto.ClusterStorage{Class: *storageClass}
+		if *storageSize != "" {
+			size, err := resource.ParseQuantity(*storageSize)
+			if err != nil {
+				return &usageError{"invalid storage size: " + err.Error()}
+			}
+			options.Storage.Size = &size
+		}
+	}
+	// kanto defaults are used for placement flags that are not set
+	placement := &kanto.ClusterPlacement{}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "anti-affinity":
+			placement.AntiAffinity = *antiAffinity
+			options.Placement = placement
+		case "spread-zones":
+			placement.SpreadZones = spreadZones
+			options.Placement = placement
+		}
+	})
+	cluster, op, err := c.Create(*tag, int32(*replicas), options)
+	return finishCreate(c, out, cluster, op, err, *wait, *timeout)
+}
+
+func deleteCommand(c *client.Client, out *output, args []string) error {
+	fs := newFlagSet("delete")
+	wait := fs.Bool("wait", false, "wait until operation finishes")
+	timeout := fs.Duration("timeout", DEFAULT_WAIT, "max time to wait")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	tag, err := oneArg(positional, "cluster tag")
+	if err != nil {
+		return err
+	}
+	_, op, err := c.Delete(tag)
+	return finishOperation(c, out, op, err, *wait, *timeout)
+}
+
+func scaleCommand(c *client.Client, out *output, args []string) error {
+	fs := newFlagSet("scale")
+	replicas := fs.Int("replicas", 0, "new number of replicas, current number is kept if not set")
+	resourceFlags := newResourceFlags(fs)
+	wait := fs.Bool("wait", false, "wait until operation finishes")
+	timeout := fs.Duration("timeout", DEFAULT_WAIT, "max time to wait")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	tag, err := oneArg(positional, "cluster tag")
+	if err != nil {
+		return err
+	}
+	if *replicas < 0 {
+		return &usageError{"--replicas has to be positive"}
+	}
+	resources, err := resourceFlags.resources()
+	if err != nil {
+		return err
+	}
+	// without --replicas only resources are changed
+	if *replicas == 0 && resources == nil {
+		return &usageError{"--replicas or resource flag is required"}
+	}
+	_, op, err := c.Scale(tag, int32(*replicas), resources)
+	return finishOperation(c, out, op, err, *wait, *timeout)
+}
+
+// cpu and memory flags of create and scale
+type resourceFlags struct {
+	cpuRequest, cpuLimit, memoryRequest, memoryLimit *string
+}
+
+// register resource flags in flag set
+func newResourceFlags(fs *flag.FlagSet) *resourceFlags {
+	return &resourceFlags{
+		cpuRequest:    fs.String("cpu-request", "", "cpu request of couchdb container, ie. 500m"),
+		cpuLimit:      fs.String("cpu-limit", "", "cpu limit of couchdb container"),
+		memoryRequest: fs.String("memory-request", "", "memory request of couchdb container, ie. 512Mi"),
+		memoryLimit:   fs.String("memory-limit", "", "memory limit of couchdb container"),
+	}
+}
+
+// parse resource flags
+// @return *api.ResourceRequirements - nil if no flag is set
+// @return error - invalid quantity
+func (f *resourceFlags) resources() (*api.ResourceRequirements, error) {
+	requests, err := kanto.ParseResourceList(map[api.ResourceName]string{
+		api.ResourceCPU:    *f.cpuRequest,
+		api.ResourceMemory: *f.memoryRequest,
+	})
+	if err != nil {
+		return nil, &usageError{"invalid resource request: " + err.Error()}
+	}
+	limits, err := kanto.ParseResourceList(map[api.ResourceName]string{
+		api.ResourceCPU:    *f.cpuLimit,
+		api.ResourceMemory: *f.memoryLimit,
+	})
+	if err != nil {
+		return nil, &usageError{"invalid resource limit: " + err.Error()}
+	}
+	if len(requests) == 0 && len(limits) == 0 {
+		return nil, nil
+	}
+	return &api.ResourceRequirements{Requests: requests, Limits: limits}, nil
+}
+
+func resizeCommand(c *client.Client, out *output, args []string) error {
+	fs := newFlagSet("resize")
+	storageSize := fs.String("storage-size", "", "new size of volume of each replica, ie. 20Gi")
+	wait := fs.Bool("wait", false, "wait until operation finishes")
+	timeout := fs.Duration("timeout", DEFAULT_WAIT, "max time to wait")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	tag, err := oneArg(positional, "cluster tag")
+	if err != nil {
+		return err
+	}
+	if *storageSize == "" {
+		return &usageError{"--storage-size is required"}
+	}
+	size, err := resource.ParseQuantity(*storageSize)
+	if err != nil {
+		return &usageError{"invalid storage size: " + err.Error()}
+	}
+	_, op, err := c.Resize(tag, size)
+	return finishOperation(c, out, op, err, *wait, *timeout)
+}
+
+func replicateCommand(c *client.Client, out *output, args []string) error {
+	fs := newFlagSet("replicate")
+	databases := fs.String("databases", "", "comma separated list of databases")
+	wait := fs.Bool("wait", false, "wait until operation finishes")
+	timeout := fs.Duration("timeout", DEFAULT_WAIT, "max time to wait")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	tag, err := oneArg(positional, "cluster tag")
+	if err != nil {
+		return err
+	}
+	if *databases == "" {
+		return &usageError{"--databases is required"}
+	}
+	_, op, err := c.Replicate(tag, strings.Split(*databases, ","))
+	return finishOperation(c, out, op, err, *wait, *timeout)
+}
+
+func listCommand(c *client.Client, out *output, args []string) error {
+	positional, err := parseArgs(newFlagSet("list"), args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return &usageError{"unexpected argument: " + positional[0]}
+	}
+	clusters, err := c.List()
+	if err != nil {
+		return err
+	}
+	return out.clusters(clusters)
+}
+
+func detailCommand(c *client.Client, out *output, args []string) error {
+	positional, err := parseArgs(newFlagSet("detail"), args)
+	if err != nil {
+		return err
+	}
+	tag, err := oneArg(positional, "cluster tag")
+	if err != nil {
+		return err
+	}
+	cluster, err := c.Detail(tag)
+	if err != nil {
+		return err
+	}
+	return out.clusters([]kanto.CouchdbCluster{*cluster})
+}
+
+func credentialsCommand(c *client.Client, out *output, args []string) error {
+	positional, err := parseArgs(newFlagSet("credentials"), args)
+	if err != nil {
+		return err
+	}
+	tag, err := oneArg(positional, "cluster tag")
+	if err != nil {
+		return err
+	}
+	cluster, err := c.Credentials(tag)
+	if err != nil {
+		return err
+	}
+	if done, err := out.structured(cluster); done {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TAG\tENDPOINT\tUSERNAME\tPASSWORD")
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", cluster.Tag, cluster.Endpoint, cluster.Username, cluster.Password)
+	return w.Flush()
+}
+
+func rotateCredentialsCommand(c *client.Client, out *output, args []string) error {
+	fs := newFlagSet("rotate-credentials")
+	wait := fs.Bool("wait", false, "wait until operation finishes")
+	timeout := fs.Duration("timeout", DEFAULT_WAIT, "max time to wait")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	tag, err := oneArg(positional, "cluster tag")
+	if err != nil {
+		return err
+	}
+	_, op, err := c.RotateCredentials(tag)
+	return finishOperation(c, out, op, err, *wait, *timeout)
+}
+
+func waitCommand(c *client.Client, out *output, args []string) error {
+	fs := newFlagSet("wait")
+	timeout := fs.Duration("timeout", DEFAULT_WAIT, "max time to wait")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	id, err := oneArg(positional, "operation id")
+	if err != nil {
+		return err
+	}
+	op, err := c.Operation(id)
+	return finishOperation(c, out, op, err, true, *timeout)
+}
+
+// optionally wait for operation and print it
+// @return error - request error or error of failed operation
+func finishOperation(c *client.Client, out *output, op *kanto.Operation, err error, wait bool, timeout time.Duration) error {
+	if err != nil {
+		return err
+	}
+	if wait {
+		var waitErr error
+		op, waitErr = c.Wait(op.Id, timeout)
+		if op == nil {
+			return waitErr
+		}
+		if err := out.operation(op); err != nil {
+			return err
+		}
+		return waitErr
+	}
+	return out.operation(op)
+}
+
+// print create operation or wait for it and print finished operation
+// operation returned by Wait has no admin password, so generated password from create response is put back to its result
+func finishCreate(c *client.Client, out *output, cluster *kanto.CouchdbCluster, op *kanto.Operation, err error,
+	wait bool, timeout time.Duration) error {
+	if err != nil || !wait {
+		return finishOperation(c, out, op, err, wait, timeout)
+	}
+	created := op
+	op, waitErr := c.Wait(op.Id, timeout)
+	if op == nil {
+		return waitErr
+	}
+	if cluster != nil && cluster.Password != "" {
+		if op.Result == nil {
+			op.Result = created.Result
+		}
+		content, err := op.MarshalWithPassword(cluster.Password)
+		if err != nil {
+			return err
+		}
+		withPassword := &kanto.Operation{}
+		if err := json.Unmarshal(content, withPassword); err != nil {
+			return err
+		}
+		op = withPassword
+	}
+	if err := out.operation(op); err != nil {
+		return err
+	}
+	return waitErr
+}
+
+// output printer
+type output struct {
+	format string
+}
+
+// print value as json or yaml
+// @return bool - true if value was printed
+func (o *output) structured(v interface{}) (bool, error) {
+	var content []byte
+	var err error
+	switch o.format {
+	case OUTPUT_JSON:
+		content, err = json.MarshalIndent(v, "", "  ")
+		content = append(content, '\n')
+	case OUTPUT_YAML:
+		content, err = yaml.Marshal(v)
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	_, err = os.Stdout.Write(content)
+	return true, err
+}
+
+// print clusters
+func (o *output) clusters(clusters []kanto.CouchdbCluster) error {
+	if done, err := o.structured(clusters); done {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TAG\tREPLICAS\tENDPOINT\tNAMESPACE\tSPAWNER\tCPU\tMEMORY\tSTORAGE\tREPLICATION\tDATABASES")
+	for _, cluster := range clusters {
+		replication := ""
+		if cluster.Reconcile != nil {
+			replication = cluster.Reconcile.State
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", cluster.Tag, cluster.Replicas, cluster.Endpoint,
+			cluster.Namespace, cluster.SpawnerType, resourceColumn(cluster.Resources, api.ResourceCPU),
+			resourceColumn(cluster.Resources, api.ResourceMemory), storageColumn(cluster.Storage), replication,
+			strings.Join(cluster.Databases, ","))
+	}
+	return w.Flush()
+}
+
+// storage size with class in brackets, "-" if cluster has no storage
+func storageColumn(storage *kanto.ClusterStorage) string {
+	if storage == nil || storage.Size == nil {
+		return "-"
+	}
+	if storage.Class != "" {
+		return storage.Size.String() + " (" + storage.Class + ")"
+	}
+	return storage.Size.String()
+}
+
+// request and limit of resource as "request/limit", missing value is "-"
+func resourceColumn(resources *api.ResourceRequirements, name api.ResourceName) string {
+	if resources == nil {
+		return "-/-"
+	}
+	request, limit := "-", "-"
+	if quantity, ok := resources.Requests[name]; ok {
+		request = quantity.String()
+	}
+	if quantity, ok := resources.Limits[name]; ok {
+		limit = quantity.String()
+	}
+	return request + "/" + limit
+}
+
+// print operation
+func (o *output) operation(op *kanto.Operation) error {
+	if done, err := o.structured(op); done {
+		return err
+	}
+	cluster, err := client.OperationCluster(op)
+	if err != nil {
+		return errors.New("invalid operation result: " + err.Error())
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "OPERATION\tTYPE\tCLUSTER\tSTATUS\tENDPOINT")
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", op.Id, op.Type, op.ClusterTag, op.Status, cluster.Endpoint)
+	// admin password is present only in create response
+	if cluster.Password != "" {
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, "USERNAME\tPASSWORD")
+		fmt.Fprintf(w, "%s\t%s\n", cluster.Username, cluster.Password)
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "STEP\tSTATUS")
+	for _, step := range op.Steps {
+		fmt.Fprintf(w, "%s\t%s\n", step.Name, step.Status)
+	}
+	if op.Error != "" {
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, "ERROR\t"+op.Error)
+	}
+	// resources deleted after failed create
+	if op.Rollback != nil {
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, "ROLLBACK\tSTATUS")
+		for _, name := range op.Rollback.RolledBack {
+			fmt.Fprintf(w, "%s\t%s\n", name, "deleted")
+		}
+		for name, err := range op.Rollback.Failed {
+			fmt.Fprintf(w, "%s\t%s\n", name, "failed: "+err)
+		}
+	}
+	return w.Flush()
+}
@@ -8,9 +8,17 @@ package main
 // imports
 import (
 	"./kanto"
+	"errors"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 // constants
@@ -38,23 +46,122 @@ func main() {
 	// init random generator
 	kanto.InitRandom()
 
-	// load kubernetes api from os env if configured
-	env_kube_api := os.Getenv("KUBERNETES_API_URL")
-	if env_kube_api != "" {
-		kanto.KUBE_API = env_kube_api
-		kanto.InfoLog("ENV: kubernetes API url set to: "+env_kube_api)
-	} else {
-		kanto.InfoLog("ENV: kubernetes API url set to default ("+kanto.KUBE_API+"), use env \"KUBERNETES_API_URL\" to set to different value")
+	// load kubernetes api and credentials from os env
+	err := ConfigureKubeClient()
+	if err != nil {
+		kanto.ErrorLog("cannot configure kubernetes client")
+		kanto.ErrorLog(err)
+		return
 	}
 
 	// load spawner type
 	env_spawner_type := os.Getenv("SPAWNER_TYPE")
 	// check env value
-	if env_spawner_type == kanto.COMPONENT_RC || env_spawner_type == kanto.COMPONENT_PETSET {
+	if _, err := kanto.GetSpawner(env_spawner_type); env_spawner_type != "" && err == nil {
 		kanto.SPAWNER_TYPE = env_spawner_type
 		kanto.InfoLog("ENV: kanto spawner component set to: \""+env_spawner_type+"\"")
 	} else {
-		kanto.InfoLog("ENV: kanto spawner component set to default (\""+kanto.SPAWNER_TYPE+"\"), use env \"SPAWNER_TYPE\" to change default spawner. Possible values: rc, deployment (no pv))")
+		kanto.InfoLog("ENV: kanto spawner component set to default (\""+kanto.SPAWNER_TYPE+"\"), use env \"SPAWNER_TYPE\" to change default spawner. Possible values: rc, petset, deployment (no pv))")
+	}
+
+	// load kubernetes cluster dns domain, used for dns names of pet set pods
+	if env_cluster_domain := os.Getenv("CLUSTER_DOMAIN"); env_cluster_domain != "" {
+		kanto.CLUSTER_DOMAIN = env_cluster_domain
+		kanto.InfoLog("ENV: kubernetes cluster domain set to: "+env_cluster_domain)
+	}
+
+	// load deadlines for waiting on kubernetes resources
+	err = ConfigureTimeouts()
+	if err != nil {
+		kanto.ErrorLog("cannot configure timeouts")
+		kanto.ErrorLog(err)
+		return
+	}
+
+	// load timings of couchdb readiness and liveness probes
+	err = ConfigureProbes()
+	if err != nil {
+		kanto.ErrorLog("cannot configure probes")
+		kanto.ErrorLog(err)
+		return
+	}
+
+	// load cpu and memory bounds and defaults of couchdb containers
+	err = ConfigureResources()
+	if err != nil {
+		kanto.ErrorLog("cannot configure cluster resources")
+		kanto.ErrorLog(err)
+		return
+	}
+
+	// load size limits and storage classes of volume claims
+	err = ConfigureStorage()
+	if err != nil {
+		kanto.ErrorLog("cannot configure cluster storage")
+		kanto.ErrorLog(err)
+		return
+	}
+
+	// load default anti-affinity and zone spreading of replicas
+	err = ConfigurePlacement()
+	if err != nil {
+		kanto.ErrorLog("cannot configure cluster placement")
+		kanto.ErrorLog(err)
+		return
+	}
+
+	// pod disruption budgets need policy api
+	if os.Getenv("DISRUPTION_BUDGET") == "false" {
+		kanto.DISRUPTION_BUDGET = false
+		kanto.InfoLog("ENV: pod disruption budgets disabled (DISRUPTION_BUDGET=false)")
+	}
+
+	// load exposure types of clusters outside of kubernetes
+	err = ConfigureExposure()
+	if err != nil {
+		kanto.ErrorLog("cannot configure cluster exposure")
+		kanto.ErrorLog(err)
+		return
+	}
+
+	// load authentication backend
+	err = ConfigureAuthenticator()
+	if err != nil {
+		kanto.ErrorLog("cannot configure authentication")
+		kanto.ErrorLog(err)
+		return
+	}
+
+	// load metadata store
+	err = ConfigureMetadataStore()
+	if err != nil {
+		kanto.ErrorLog("cannot open metadata store")
+		kanto.ErrorLog(err)
+		return
+	}
+
+	// load tenant namespace configuration
+	err = ConfigureTenants()
+	if err != nil {
+		kanto.ErrorLog("cannot configure tenant namespaces")
+		kanto.ErrorLog(err)
+		return
+	}
+
+	// start shared kubernetes cache, reconciler uses its informers
+	err = ConfigureKubeCache()
+	if err != nil {
+		kanto.ErrorLog("cannot start kubernetes cache")
+		kanto.ErrorLog(err)
+		return
+	}
+
+	// start replication reconciliation
+	err = ConfigureReconciler()
+	if err != nil {
+		kanto.ErrorLog("cannot configure replication reconciler")
+		kanto.ErrorLog(err)
+		return
 	}
 
 	// start kanto web service
@@ -83,3 +190,469 @@ func StartWebService() {
 	// wait for errors
 	kanto.ErrorLog(<-errChan)
 }
+
+// configure kubernetes client from os env
+// KUBECONFIG, KUBE_CONTEXT - kubeconfig file and its context (defaults to current context)
+// KUBE_IN_CLUSTER - "true" uses service account of kanto pod, enabled automatically when kanto runs in pod
+//   and neither KUBERNETES_API_URL nor KUBECONFIG is set, "false" disables it
+// KUBERNETES_API_URL - api url, overrides url from kubeconfig
+// KUBE_TOKEN, KUBE_TOKEN_FILE - bearer token
+// KUBE_CLIENT_CERT, KUBE_CLIENT_KEY - client certificate
+// KUBE_CA_FILE - CA for api server certificate, KUBE_INSECURE_SKIP_TLS_VERIFY=true disables verification
+// @param none
+// @return error
+func ConfigureKubeClient() error {
+	env_kube_api := os.Getenv("KUBERNETES_API_URL")
+	options := kanto.KubeAuthOptions{
+		Host:       env_kube_api,
+		Kubeconfig: os.Getenv("KUBECONFIG"),
+		Context:    os.Getenv("KUBE_CONTEXT"),
+		Token:      os.Getenv("KUBE_TOKEN"),
+		TokenFile:  os.Getenv("KUBE_TOKEN_FILE"),
+		CertFile:   os.Getenv("KUBE_CLIENT_CERT"),
+		KeyFile:    os.Getenv("KUBE_CLIENT_KEY"),
+		CAFile:     os.Getenv("KUBE_CA_FILE"),
+		Insecure:   os.Getenv("KUBE_INSECURE_SKIP_TLS_VERIFY") == "true",
+	}
+	env_in_cluster := os.Getenv("KUBE_IN_CLUSTER")
+	options.InCluster = env_in_cluster == "true" || (env_in_cluster != "false" && env_kube_api == "" &&
+		options.Kubeconfig == "" && os.Getenv("KUBERNETES_SERVICE_HOST") != "")
+
+	switch {
+	case options.Kubeconfig != "":
+		kanto.InfoLog("ENV: kubernetes client configured from kubeconfig: "+options.Kubeconfig+", context: "+options.Context)
+	case options.InCluster:
+		kanto.InfoLog("ENV: kubernetes client configured with service account of kanto pod")
+	case env_kube_api != "":
+		kanto.InfoLog("ENV: kubernetes API url set to: "+env_kube_api)
+	default:
+		options.Host = kanto.KUBE_API
+		kanto.InfoLog("ENV: kubernetes API url set to default ("+kanto.KUBE_API+"), use env \"KUBERNETES_API_URL\", \"KUBECONFIG\" or \"KUBE_IN_CLUSTER\" to set to different value")
+	}
+	config, err := kanto.LoadKubeConfig(options)
+	if err != nil {
+		return err
+	}
+	kanto.KUBE_CONFIG = config
+	kanto.KUBE_API = config.Host
+	return nil
+}
+
+// configure deadlines from os env, values are go durations (ie. "90s", "5m")
+// POD_READY_TIMEOUT - max time to wait for pods to become ready
+// DELETE_TIMEOUT - max time to wait for deleted pods, replica sets and controllers to disappear
+// RESIZE_TIMEOUT - max time to wait for one expanded volume or one replica seeded after move to new volume
+// @param none
+// @return error - invalid duration
+func ConfigureTimeouts() error {
+	if env_timeout := os.Getenv("POD_READY_TIMEOUT"); env_timeout != "" {
+		timeout, err := time.ParseDuration(env_timeout)
+		if err != nil {
+			return err
+		}
+		kanto.POD_READY_TIMEOUT = timeout
+		kanto.InfoLog("ENV: pod ready timeout set to: "+env_timeout)
+	}
+	if env_timeout := os.Getenv("DELETE_TIMEOUT"); env_timeout != "" {
+		timeout, err := time.ParseDuration(env_timeout)
+		if err != nil {
+			return err
+		}
+		kanto.DELETE_TIMEOUT = timeout
+		kanto.InfoLog("ENV: delete timeout set to: "+env_timeout)
+	}
+	if env_timeout := os.Getenv("RESIZE_TIMEOUT"); env_timeout != "" {
+		timeout, err := time.ParseDuration(env_timeout)
+		if err != nil {
+			return err
+		}
+		kanto.RESIZE_TIMEOUT = timeout
+		kanto.InfoLog("ENV: resize timeout set to: "+env_timeout)
+	}
+	return nil
+}
+
+// start shared kubernetes cache, unless disabled by os env KUBE_CACHE=false
+// @param none
+// @return error
+func ConfigureKubeCache() error {
+	if os.Getenv("KUBE_CACHE") == "false" {
+		kanto.InfoLog("ENV: kubernetes cache disabled (KUBE_CACHE=false), every request asks kubernetes api")
+		return nil
+	}
+	kubeCache, err := kanto.StartKubeCache()
+	if err != nil {
+		return err
+	}
+	kanto.KUBE_CACHE = kubeCache
+	return nil
+}
+
+// configure and start replication reconciler from os env
+// RECONCILE_REPLICATION - "false" disables reconciler, replication is then configured only by operations
+// RECONCILE_RESYNC_PERIOD - go duration, all clusters are checked after this period even without pod events
+// RECONCILE_WORKERS - number of clusters reconciled at same time
+// @param none
+// @return error - invalid duration or number of workers
+func ConfigureReconciler() error {
+	if os.Getenv("RECONCILE_REPLICATION") == "false" {
+		kanto.InfoLog("ENV: replication reconciler disabled (RECONCILE_REPLICATION=false)")
+		return nil
+	}
+	if env_period := os.Getenv("RECONCILE_RESYNC_PERIOD"); env_period != "" {
+		period, err := time.ParseDuration(env_period)
+		if err != nil {
+			return err
+		}
+		kanto.RECONCILE_RESYNC_PERIOD = period
+		kanto.InfoLog("ENV: reconciler resync period set to: "+env_period)
+	}
+	if env_workers := os.Getenv("RECONCILE_WORKERS"); env_workers != "" {
+		workers, err := strconv.Atoi(env_workers)
+		if err != nil {
+			return err
+		}
+		if workers < 1 {
+			return errors.New("RECONCILE_WORKERS has to be at least 1")
+		}
+		kanto.RECONCILE_WORKERS = workers
+		kanto.InfoLog("ENV: reconciler workers set to: "+env_workers)
+	}
+	kanto.RECONCILER = kanto.StartReconciler()
+	return nil
+}
+
+// configure authenticator from os env
+// AUTH_TYPE - "file" (default) or "none" (no authentication, development only)
+// AUTH_FILE - path to user store file used by "file" authenticator
+// @param none
+// @return error
+func ConfigureAuthenticator() error {
+	env_auth_type := os.Getenv("AUTH_TYPE")
+	if env_auth_type == kanto.AUTH_TYPE_NONE {
+		kanto.AUTHENTICATOR = &kanto.AllowAllAuthenticator{}
+		kanto.InfoLog("ENV: authentication disabled (AUTH_TYPE=none), do not use in production")
+		return nil
+	}
+
+	// file authenticator
+	env_auth_file := os.Getenv("AUTH_FILE")
+	if env_auth_file != "" {
+		kanto.AUTH_FILE = env_auth_file
+		kanto.InfoLog("ENV: user store file set to: "+env_auth_file)
+	} else {
+		kanto.InfoLog("ENV: user store file set to default ("+kanto.AUTH_FILE+"), use env \"AUTH_FILE\" to set to different value")
+	}
+	authenticator, err := kanto.NewFileAuthenticator(kanto.AUTH_FILE)
+	if err != nil {
+		return err
+	}
+	kanto.AUTHENTICATOR = authenticator
+
+	// reload user file on SIGHUP, file is also reloaded automatically when changed
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := authenticator.Reload(); err != nil {
+				kanto.ErrorLog("reload of user store file failed")
+				kanto.ErrorLog(err)
+			} else {
+				kanto.InfoLog("user store file reloaded")
+			}
+		}
+	}()
+	return nil
+}
+
+// configure persistent metadata store from os env
+// METADATA_STORE - "file" (default) or "couchdb"
+// METADATA_FILE - path to json file used by "file" store
+// METADATA_COUCHDB_URL, METADATA_COUCHDB_USER, METADATA_COUCHDB_PASSWORD, METADATA_COUCHDB_DATABASE - used by "couchdb" store
+// @param none
+// @return error
+func ConfigureMetadataStore() error {
+	env_store_type := os.Getenv("METADATA_STORE")
+	if env_store_type == kanto.METADATA_STORE_COUCHDB {
+		// couchdb store
+		url := os.Getenv("METADATA_COUCHDB_URL")
+		database := os.Getenv("METADATA_COUCHDB_DATABASE")
+		if database == "" {
+			database = "kanto_metadata"
+		}
+		kanto.InfoLog("ENV: metadata store set to couchdb: "+url+", database: "+database)
+		store, err := kanto.NewCouchdbMetadataStore(url, os.Getenv("METADATA_COUCHDB_USER"),
+						os.Getenv("METADATA_COUCHDB_PASSWORD"), database)
+		if err != nil {
+			return err
+		}
+		kanto.METADATA_STORE = store
+		return nil
+	}
+
+	// file store
+	env_metadata_file := os.Getenv("METADATA_FILE")
+	if env_metadata_file != "" {
+		kanto.METADATA_FILE = env_metadata_file
+		kanto.InfoLog("ENV: metadata store file set to: "+env_metadata_file)
+	} else {
+		kanto.InfoLog("ENV: metadata store file set to default ("+kanto.METADATA_FILE+"), use env \"METADATA_FILE\" to set to different value or \"METADATA_STORE=couchdb\" to use couchdb")
+	}
+	store, err := kanto.NewFileMetadataStore(kanto.METADATA_FILE)
+	if err != nil {
+		return err
+	}
+	kanto.METADATA_STORE = store
+	return nil
+}
+
+// configure tenant namespaces from os env
+// NAMESPACE_MODE - "shared" (default, all clusters in namespace "default"), "user" or "team" (namespace per user or team)
+// NAMESPACE_TEAMS_FILE - "username:team" file used in "team" mode
+// TENANT_QUOTA_PODS, TENANT_QUOTA_PVC, TENANT_QUOTA_CPU, TENANT_QUOTA_MEMORY - resource quota of tenant namespace
+// TENANT_LIMIT_CPU, TENANT_LIMIT_MEMORY, TENANT_REQUEST_CPU, TENANT_REQUEST_MEMORY - default container limits and requests
+// quota and limit values are kubernetes quantities, empty value disables the limit
+// @param none
+// @return error
+func ConfigureTenants() error {
+	env_mode := os.Getenv("NAMESPACE_MODE")
+	if env_mode != kanto.NAMESPACE_MODE_USER && env_mode != kanto.NAMESPACE_MODE_TEAM {
+		kanto.InfoLog("ENV: all clusters are in namespace \"default\", use env \"NAMESPACE_MODE\" (user, team) to use namespace per tenant")
+		return nil
+	}
+	tenants := &kanto.TenantConfig{Mode: env_mode}
+	kanto.InfoLog("ENV: namespace mode set to: "+env_mode)
+
+	// teams
+	if env_mode == kanto.NAMESPACE_MODE_TEAM {
+		if env_teams_file := os.Getenv("NAMESPACE_TEAMS_FILE"); env_teams_file != "" {
+			kanto.NAMESPACE_TEAMS_FILE = env_teams_file
+		}
+		kanto.InfoLog("ENV: teams file set to: "+kanto.NAMESPACE_TEAMS_FILE)
+		teams, err := kanto.LoadTeams(kanto.NAMESPACE_TEAMS_FILE)
+		if err != nil {
+			return err
+		}
+		tenants.Teams = teams
+	}
+
+	// resource quota and default limits
+	var err error
+	tenants.Quota, err = kanto.ParseResourceList(map[api.ResourceName]string{
+		api.ResourcePods:                   envDefault("TENANT_QUOTA_PODS", "20"),
+		api.ResourcePersistentVolumeClaims: envDefault("TENANT_QUOTA_PVC", "20"),
+		api.ResourceLimitsCPU:              envDefault("TENANT_QUOTA_CPU", "8"),
+		api.ResourceLimitsMemory:           envDefault("TENANT_QUOTA_MEMORY", "16Gi"),
+	})
+	if err != nil {
+		return err
+	}
+	tenants.DefaultLimits, err = kanto.ParseResourceList(map[api.ResourceName]string{
+		api.ResourceCPU:    envDefault("TENANT_LIMIT_CPU", "1"),
+		api.ResourceMemory: envDefault("TENANT_LIMIT_MEMORY", "1Gi"),
+	})
+	if err != nil {
+		return err
+	}
+	tenants.DefaultRequests, err = kanto.ParseResourceList(map[api.ResourceName]string{
+		api.ResourceCPU:    envDefault("TENANT_REQUEST_CPU", "100m"),
+		api.ResourceMemory: envDefault("TENANT_REQUEST_MEMORY", "256Mi"),
+	})
+	if err != nil {
+		return err
+	}
+	kanto.TENANTS = tenants
+	return nil
+}
+
+// configure http probes of couchdb containers from os env, values are whole seconds
+// READINESS_PROBE_INITIAL_DELAY, READINESS_PROBE_TIMEOUT, READINESS_PROBE_PERIOD, READINESS_PROBE_FAILURE_THRESHOLD
+// LIVENESS_PROBE_INITIAL_DELAY, LIVENESS_PROBE_TIMEOUT, LIVENESS_PROBE_PERIOD, LIVENESS_PROBE_FAILURE_THRESHOLD
+// FAILURE_THRESHOLD is number of failed probes in a row
+// @param none
+// @return error - invalid number
+func ConfigureProbes() error {
+	probes := map[string]*kanto.ProbeTimings{"READINESS_PROBE": &kanto.READINESS_PROBE, "LIVENESS_PROBE": &kanto.LIVENESS_PROBE}
+	for prefix, timings := range probes {
+		values := map[string]*int32{
+			prefix + "_INITIAL_DELAY":     &timings.InitialDelaySeconds,
+			prefix + "_TIMEOUT":           &timings.TimeoutSeconds,
+			prefix + "_PERIOD":            &timings.PeriodSeconds,
+			prefix + "_FAILURE_THRESHOLD": &timings.FailureThreshold,
+		}
+		for name, value := range values {
+			env_value := os.Getenv(name)
+			if env_value == "" {
+				continue
+			}
+			number, err := strconv.Atoi(env_value)
+			// kubernetes requires at least 1 for everything except initial delay
+			if err != nil || number < 0 || (number == 0 && name != prefix+"_INITIAL_DELAY") {
+				return errors.New("invalid value of "+name+": "+env_value)
+			}
+			*value = int32(number)
+			kanto.InfoLog("ENV: "+name+" set to: "+env_value)
+		}
+	}
+	return nil
+}
+
+// configure cpu and memory of couchdb containers from os env
+// CLUSTER_MIN_CPU, CLUSTER_MAX_CPU, CLUSTER_MIN_MEMORY, CLUSTER_MAX_MEMORY - bounds of requests and limits set by users
+// CLUSTER_REQUEST_CPU, CLUSTER_REQUEST_MEMORY, CLUSTER_LIMIT_CPU, CLUSTER_LIMIT_MEMORY - defaults for new clusters
+// values are kubernetes quantities, empty value disables the bound or default
+// @param none
+// @return error
+func ConfigureResources() error {
+	var err error
+	kanto.RESOURCES_MIN, err = kanto.ParseResourceList(map[api.ResourceName]string{
+		api.ResourceCPU:    envDefault("CLUSTER_MIN_CPU", "100m"),
+		api.ResourceMemory: envDefault("CLUSTER_MIN_MEMORY", "128Mi"),
+	})
+	if err != nil {
+		return err
+	}
+	kanto.RESOURCES_MAX, err = kanto.ParseResourceList(map[api.ResourceName]string{
+		api.ResourceCPU:    envDefault("CLUSTER_MAX_CPU", "4"),
+		api.ResourceMemory: envDefault("CLUSTER_MAX_MEMORY", "8Gi"),
+	})
+	if err != nil {
+		return err
+	}
+	for name, min := range kanto.RESOURCES_MIN {
+		if max, ok := kanto.RESOURCES_MAX[name]; ok && min.Cmp(max) > 0 {
+			return errors.New("minimum "+string(name)+" is higher than maximum")
+		}
+	}
+
+	defaults := api.ResourceRequirements{}
+	defaults.Requests, err = kanto.ParseResourceList(map[api.ResourceName]string{
+		api.ResourceCPU:    envDefault("CLUSTER_REQUEST_CPU", "100m"),
+		api.ResourceMemory: envDefault("CLUSTER_REQUEST_MEMORY", "256Mi"),
+	})
+	if err != nil {
+		return err
+	}
+	defaults.Limits, err = kanto.ParseResourceList(map[api.ResourceName]string{
+		api.ResourceCPU:    envDefault("CLUSTER_LIMIT_CPU", "1"),
+		api.ResourceMemory: envDefault("CLUSTER_LIMIT_MEMORY", "1Gi"),
+	})
+	if err != nil {
+		return err
+	}
+	// defaults have to be valid for every user
+	if err = kanto.ValidateResources(&defaults); err != nil {
+		return err
+	}
+	kanto.DEFAULT_RESOURCES = defaults
+	kanto.InfoLog("ENV: cluster resources bounds and defaults loaded")
+	return nil
+}
+
+// configure volume claims of couchdb pods from os env
+// STORAGE_MIN_SIZE, STORAGE_MAX_SIZE - bounds of storage size chosen by users, empty value disables the bound
+// STORAGE_DEFAULT_SIZE - size of claims when user does not choose it
+// STORAGE_CLASSES - comma separated storage classes users can choose, default class is always allowed
+// STORAGE_DEFAULT_CLASS - storage class when user does not choose it, empty means default class of kubernetes
+// @param none
+// @return error
+func ConfigureStorage() error {
+	sizes := map[string]**resource.Quantity{
+		"STORAGE_MIN_SIZE":     &kanto.STORAGE_MIN_SIZE,
+		"STORAGE_MAX_SIZE":     &kanto.STORAGE_MAX_SIZE,
+		"STORAGE_DEFAULT_SIZE": &kanto.DEFAULT_STORAGE.Size,
+	}
+	defaults := map[string]string{"STORAGE_MIN_SIZE": "1Gi", "STORAGE_MAX_SIZE": "100Gi"}
+	for name, size := range sizes {
+		env_size := envDefault(name, defaults[name])
+		if env_size == "" {
+			if name != "STORAGE_DEFAULT_SIZE" {
+				*size = nil
+			}
+			continue
+		}
+		quantity, err := resource.ParseQuantity(env_size)
+		if err != nil {
+			return errors.New("invalid value of "+name+": "+env_size)
+		}
+		*size = &quantity
+	}
+	if env_classes := os.Getenv("STORAGE_CLASSES"); env_classes != "" {
+		kanto.STORAGE_CLASSES = strings.Split(env_classes, ",")
+		kanto.InfoLog("ENV: allowed storage classes set to: "+env_classes)
+	}
+	if env_class := os.Getenv("STORAGE_DEFAULT_CLASS"); env_class != "" {
+		kanto.DEFAULT_STORAGE.Class = env_class
+		kanto.STORAGE_CLASSES = append(kanto.STORAGE_CLASSES, env_class)
+		kanto.InfoLog("ENV: default storage class set to: "+env_class)
+	}
+	// default storage has to be valid for every user
+	return kanto.ValidateStorage(&kanto.DEFAULT_STORAGE)
+}
+
+// configure placement of couchdb pods from os env
+// ANTI_AFFINITY_DEFAULT - anti-affinity of replicas when user does not choose it (none, preferred, required)
+// SPREAD_ZONES_DEFAULT - "true" prefers replicas in different zones when user does not choose it
+// @param none
+// @return error
+func ConfigurePlacement() error {
+	if env_affinity := os.Getenv("ANTI_AFFINITY_DEFAULT"); env_affinity != "" {
+		kanto.DEFAULT_PLACEMENT.AntiAffinity = env_affinity
+		kanto.InfoLog("ENV: default anti-affinity set to: "+env_affinity)
+	}
+	if env_spread := os.Getenv("SPREAD_ZONES_DEFAULT"); env_spread != "" {
+		spread, err := strconv.ParseBool(env_spread)
+		if err != nil {
+			return errors.New("invalid value of SPREAD_ZONES_DEFAULT: "+env_spread)
+		}
+		kanto.DEFAULT_PLACEMENT.SpreadZones = &spread
+		kanto.InfoLog("ENV: default zone spreading set to: "+env_spread)
+	}
+	return kanto.ValidatePlacement(&kanto.DEFAULT_PLACEMENT)
+}
+
+// configure exposure of clusters outside of kubernetes from os env
+// EXPOSURE_TYPES - comma separated exposures users can choose (internal, nodeport, loadbalancer, ingress)
+// EXPOSURE_DEFAULT - exposure when user does not choose it
+// NODE_HOST - host of kubernetes nodes reachable by users, required for nodeport
+// INGRESS_DOMAIN - domain of generated ingress hostnames, required for ingress
+// INGRESS_CLASS - ingress class of created ingresses, empty means default ingress controller
+// @param none
+// @return error
+func ConfigureExposure() error {
+	if env_types := os.Getenv("EXPOSURE_TYPES"); env_types != "" {
+		kanto.EXPOSURE_TYPES = strings.Split(env_types, ",")
+		kanto.InfoLog("ENV: allowed exposure types set to: "+env_types)
+	}
+	if env_exposure := os.Getenv("EXPOSURE_DEFAULT"); env_exposure != "" {
+		kanto.DEFAULT_EXPOSURE = env_exposure
+		kanto.InfoLog("ENV: default exposure set to: "+env_exposure)
+	}
+	kanto.NODE_HOST = os.Getenv("NODE_HOST")
+	kanto.INGRESS_DOMAIN = os.Getenv("INGRESS_DOMAIN")
+	kanto.INGRESS_CLASS = os.Getenv("INGRESS_CLASS")
+	for _, exposure := range kanto.EXPOSURE_TYPES {
+		if err := kanto.ValidateExposure(exposure); err != nil {
+			return err
+		}
+		if exposure == kanto.EXPOSURE_NODEPORT && kanto.NODE_HOST == "" {
+			return errors.New("NODE_HOST is required for nodeport exposure")
+		}
+		if exposure == kanto.EXPOSURE_INGRESS && kanto.INGRESS_DOMAIN == "" {
+			return errors.New("INGRESS_DOMAIN is required for ingress exposure")
+		}
+	}
+	// default exposure has to be allowed for every user
+	return kanto.ValidateExposure(kanto.DEFAULT_EXPOSURE)
+}
+
+// get os env value or default value when env is not set, env set to empty string returns empty string
+// @param name string - env name
+// @param value string - default value
+// @return string
+func envDefault(name string, value string) string {
+	if env_value, ok := os.LookupEnv(name); ok {
+		return env_value
+	}
+	return value
+}